@@ -1,11 +1,16 @@
 package config
 
 import (
+	"os"
 	"sync"
 
 	"github.com/gazoon/bot_libs/config"
 )
 
+// URLSecretEnv is the variable with the secret for signing button urls, it overrides security.url_secret,
+// so the secret is kept out of the config file.
+const URLSecretEnv = "REMINDER_URL_SECRET"
+
 var (
 	once     sync.Once
 	instance *ServiceConfig
@@ -20,6 +25,45 @@ type ServiceConfig struct {
 	Telegram          *config.TelegramSettings `mapstructure:"telegram" json:"telegram"`
 	TelegramPolling   *config.TelegramPolling  `mapstructure:"telegram_polling" json:"telegram_polling"`
 	Logging           *config.Logging          `mapstructure:"logging" json:"logging"`
+	Security          *SecuritySettings        `mapstructure:"security" json:"security"`
+	AdminChatIDs      []int                    `mapstructure:"admin_chat_ids" json:"admin_chat_ids"`
+	DeepLinks         []*DeepLinkSettings      `mapstructure:"deep_links" json:"deep_links"`
+	Sessions          *SessionsSettings        `mapstructure:"sessions" json:"sessions"`
+	Timezones         *TimezonesSettings       `mapstructure:"timezones" json:"timezones"`
+	Delivery          *DeliverySettings        `mapstructure:"delivery" json:"delivery"`
+}
+
+// DeliverySettings durations are in seconds.
+type DeliverySettings struct {
+	Lease         int `mapstructure:"lease" json:"lease"`
+	RetryDelay    int `mapstructure:"retry_delay" json:"retry_delay"`
+	MaxRetryDelay int `mapstructure:"max_retry_delay" json:"max_retry_delay"`
+	MaxAttempts   int `mapstructure:"max_attempts" json:"max_attempts"`
+}
+
+// TimezonesSettings.BoundariesFile is an optional GeoJSON with zones boundaries for shared locations,
+// the dataset is too big to be shipped with the bot. Use combined.json from the timezones.geojson.zip asset of
+// https://github.com/evansiroky/timezone-boundary-builder/releases. Without it a shared location gets the zone
+// of the nearest zone's principal city, which can be wrong near borders.
+type TimezonesSettings struct {
+	BoundariesFile string `mapstructure:"boundaries_file" json:"boundaries_file"`
+}
+
+// SessionsSettings values are in seconds.
+type SessionsSettings struct {
+	IdleTTL         int `mapstructure:"idle_ttl" json:"idle_ttl"`
+	StorageTTL      int `mapstructure:"storage_ttl" json:"storage_ttl"`
+	CleanupInterval int `mapstructure:"cleanup_interval" json:"cleanup_interval"`
+}
+
+type DeepLinkSettings struct {
+	Name     string `mapstructure:"name" json:"name"`
+	URL      string `mapstructure:"url" json:"url"`
+	ArgParam string `mapstructure:"arg_param" json:"arg_param"`
+}
+
+type SecuritySettings struct {
+	URLSecret string `mapstructure:"url_secret" json:"url_secret"`
 }
 
 func Initialization(configPath string) {
@@ -29,6 +73,12 @@ func Initialization(configPath string) {
 		if err != nil {
 			panic(err)
 		}
+		if secret := os.Getenv(URLSecretEnv); secret != "" {
+			if instance.Security == nil {
+				instance.Security = &SecuritySettings{}
+			}
+			instance.Security.URLSecret = secret
+		}
 	})
 }
 
@@ -0,0 +1,38 @@
+package core
+
+import (
+	"strings"
+)
+
+const (
+	StartCommand        = "start"
+	commandPrefix       = "/"
+	commandBotDelimiter = "@"
+	// CommandArgsParam is the url param with text after the command, e.g "tomorrow" for "/remind tomorrow"
+	CommandArgsParam = "args"
+)
+
+// ParseCommand splits a text like "/command@BotName args" into the command name and its args.
+// Commands addressed to other bots, e.g in group chats, are not recognized.
+func ParseCommand(text, botName string) (string, string, bool) {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, commandPrefix) {
+		return "", "", false
+	}
+	parts := strings.SplitN(text, " ", 2)
+	command := strings.TrimPrefix(parts[0], commandPrefix)
+	var args string
+	if len(parts) == 2 {
+		args = strings.TrimSpace(parts[1])
+	}
+	if idx := strings.Index(command, commandBotDelimiter); idx != -1 {
+		if !strings.EqualFold(command[idx+1:], botName) {
+			return "", "", false
+		}
+		command = command[:idx]
+	}
+	if command == "" {
+		return "", "", false
+	}
+	return strings.ToLower(command), args, true
+}
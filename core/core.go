@@ -2,20 +2,28 @@ package core
 
 import (
 	"context"
+	"fmt"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/gazoon/bot_libs/logging"
 	"github.com/gazoon/bot_libs/mongo"
 	"github.com/gazoon/bot_libs/queue/messages"
-	"github.com/pkg/errors"
-	"github.com/satori/go.uuid"
 	"github.com/globalsign/mgo"
 	"github.com/globalsign/mgo/bson"
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
 )
 
 const (
-	urlScheme = "page"
+	urlScheme      = "page"
+	AdminRole      = "admin"
+	maxHistorySize = 10
+	// maxStoredButtons is the number of recently sent long button handlers kept in the session,
+	// buttons of older messages lead to the not found page.
+	maxStoredButtons = 100
 )
 
 var (
@@ -82,6 +90,16 @@ func (u *URL) Copy() *URL {
 	return NewURL(u.Page, u.Action, u.Params)
 }
 
+// WithParam returns a copy of the url with the param set, the url itself isn't changed.
+func (u *URL) WithParam(key, value string) *URL {
+	params := make(map[string]string, len(u.Params)+1)
+	for k, v := range u.Params {
+		params[k] = v
+	}
+	params[key] = value
+	return NewURL(u.Page, u.Action, params)
+}
+
 type Message interface {
 }
 
@@ -89,6 +107,19 @@ type TextMessage struct {
 	Text string
 }
 
+// LocationMessage is a location shared by the user.
+type LocationMessage struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// DocumentMessage is a file uploaded by the user, the file is downloaded by its id.
+type DocumentMessage struct {
+	FileID   string
+	FileName string
+	FileSize int
+}
+
 type Request struct {
 	Session        *Session
 	Ctx            context.Context
@@ -99,6 +130,13 @@ type Request struct {
 	URL            *URL
 	Intents        []*Intent
 	SaveSentMsgIDs bool
+	// SentMsgIDsKey is the page state key for saved sent message ids, the default key is used if it's empty
+	SentMsgIDsKey string
+	// UserID and Username identify the sender, they are empty for requests not caused by a user message.
+	// IsGroup is set for messages from group chats, where the chat is shared by several users
+	UserID   int
+	Username string
+	IsGroup  bool
 }
 
 func NewRequestFromQueueMsg(ctx context.Context, queueMsg *msgsqueue.Message) *Request {
@@ -109,8 +147,20 @@ func NewRequestFromQueueMsg(ctx context.Context, queueMsg *msgsqueue.Message) *R
 	} else if reqURL.IsRelative() {
 		reqURL = nil
 	}
-	msg := &TextMessage{Text: msgText}
-	return &Request{Ctx: ctx, MsgText: msgText, Msg: msg, MsgID: queueMsg.MessageID, ChatID: queueMsg.Chat.ID, URL: reqURL}
+	var msg Message = &TextMessage{Text: msgText}
+	if queueMsg.Location != nil {
+		msg = &LocationMessage{Latitude: queueMsg.Location.Latitude, Longitude: queueMsg.Location.Longitude}
+	}
+	if queueMsg.Document != nil {
+		msg = &DocumentMessage{FileID: queueMsg.Document.FileID, FileName: queueMsg.Document.FileName,
+			FileSize: queueMsg.Document.FileSize}
+	}
+	req := &Request{Ctx: ctx, MsgText: msgText, Msg: msg, MsgID: queueMsg.MessageID, ChatID: queueMsg.Chat.ID,
+		IsGroup: !queueMsg.Chat.IsPrivate, URL: reqURL}
+	if queueMsg.From != nil {
+		req.UserID, req.Username = queueMsg.From.ID, queueMsg.From.Username
+	}
+	return req
 }
 
 func (r *Request) SetSession(s *Session) {
@@ -121,11 +171,22 @@ func (r *Request) SetSession(s *Session) {
 type Session struct {
 	ID           string
 	ChatID       int
+	Version      int
 	LocalIntents []*Intent
 	LastPage     *URL
 	InputHandler *URL
 	PagesStates  map[string]map[string]interface{}
 	GlobalState  map[string]interface{}
+	LastActivity time.Time
+	// History contains screens the user has seen, the current screen is the last one.
+	History []*URL
+	// StoredButtons contains handlers of sent buttons that don't fit in the button payload, the oldest one is first.
+	StoredButtons []*StoredButton
+}
+
+type StoredButton struct {
+	Key     string
+	Handler *URL
 }
 
 func NewSession(chatID int) *Session {
@@ -161,55 +222,188 @@ func (s *Session) ResetIntents(ctx context.Context) {
 	s.LocalIntents = nil
 }
 
+func (s *Session) Touch(now time.Time) {
+	s.LastActivity = now
+}
+
+// IsIdle reports whether the user hasn't interacted with the bot longer than ttl.
+func (s *Session) IsIdle(ttl time.Duration, now time.Time) bool {
+	if ttl == 0 || s.LastActivity.IsZero() {
+		return false
+	}
+	return now.Sub(s.LastActivity) > ttl
+}
+
+// Expire drops everything that handles the user input, so the next message starts from the default page.
+func (s *Session) Expire(ctx context.Context) {
+	logger := logging.FromContextAndBase(ctx, gLogger)
+	logger.Infof("Session is expired, last activity at %s", s.LastActivity)
+	s.ResetInputHandler(ctx)
+	s.ResetIntents(ctx)
+	s.SetLastPage(ctx, DefaultPageURL)
+	s.History = nil
+}
+
+func (s *Session) PushHistory(ctx context.Context, screen *URL) {
+	if len(s.History) != 0 && s.History[len(s.History)-1].Encode() == screen.Encode() {
+		return
+	}
+	logger := logging.FromContextAndBase(ctx, gLogger)
+	logger.Infof("Push %s to the history", screen.Encode())
+	s.History = append(s.History, screen)
+	if len(s.History) > maxHistorySize {
+		s.History = s.History[len(s.History)-maxHistorySize:]
+	}
+}
+
+// PopHistory drops the current screen and returns the previous one, nil if there is no previous screen.
+// The previous screen is dropped as well, it gets back to the history after the user enters it again.
+func (s *Session) PopHistory(ctx context.Context) *URL {
+	logger := logging.FromContextAndBase(ctx, gLogger)
+	if len(s.History) < 2 {
+		logger.Info("There is no previous screen in the history")
+		s.History = nil
+		return nil
+	}
+	previous := s.History[len(s.History)-2]
+	s.History = s.History[:len(s.History)-2]
+	logger.Infof("Pop %s from the history", previous.Encode())
+	return previous
+}
+
+// StoreButton keeps the button handler in the session, so the button payload can refer to it by the key.
+func (s *Session) StoreButton(key string, handler *URL) {
+	for i, button := range s.StoredButtons {
+		if button.Key == key {
+			s.StoredButtons = append(s.StoredButtons[:i], s.StoredButtons[i+1:]...)
+			break
+		}
+	}
+	s.StoredButtons = append(s.StoredButtons, &StoredButton{Key: key, Handler: handler})
+	if len(s.StoredButtons) > maxStoredButtons {
+		s.StoredButtons = s.StoredButtons[len(s.StoredButtons)-maxStoredButtons:]
+	}
+}
+
+// StoredButton returns the handler of the stored button, nil if it has been dropped.
+func (s *Session) StoredButton(key string) *URL {
+	for _, button := range s.StoredButtons {
+		if button.Key == key {
+			return button.Handler
+		}
+	}
+	return nil
+}
+
 func (s *Session) SetLastPage(ctx context.Context, newLastPage *URL) {
 	logger := logging.FromContextAndBase(ctx, gLogger)
 	logger.Infof("Change last page %s ---> %s", s.LastPage.Encode(), newLastPage.Encode())
 	s.LastPage = newLastPage
 }
 
+// Storage saves a session only if it hasn't been changed since it was read, otherwise *ConflictError is returned.
 type Storage interface {
 	Get(ctx context.Context, chatID int) (*Session, error)
 	Save(ctx context.Context, session *Session) error
 	Delete(ctx context.Context, session *Session) error
+	// DeleteInactive removes sessions with the last activity before the given time,
+	// sessions without a recorded activity are counted as active.
+	DeleteInactive(ctx context.Context, before time.Time) error
+}
+
+type ConflictError struct {
+	ChatID  int
+	Version int
+}
+
+func (ce *ConflictError) Error() string {
+	return fmt.Sprintf("session of chat %d was modified concurrently, version %d is outdated", ce.ChatID, ce.Version)
+}
+
+func IsConflict(err error) bool {
+	_, ok := errors.Cause(err).(*ConflictError)
+	return ok
+}
+
+// InMemoryStorage keeps sessions in their mongo representation,
+// so the data goes through the same bson encoding as in the mongo storage.
+type InMemoryStorage struct {
+	mx      sync.RWMutex
+	storage map[int][]byte
 }
 
-//type InMemoryStorage struct {
-//	mx      sync.RWMutex
-//	storage map[int][]byte
-//}
-//
-//func (ms *InMemoryStorage) Get(ctx context.Context, chatID int) (*Session, error) {
-//	ms.mx.RLock()
-//	sessionData, ok := ms.storage[chatID]
-//	ms.mx.RUnlock()
-//	if !ok {
-//		return nil, nil
-//	}
-//	session := &Session{}
-//	err := json.Unmarshal(sessionData, session)
-//	if err != nil {
-//		return nil, errors.Wrap(err, "session data unmarshal")
-//	}
-//	return session, nil
-//}
-//
-//func (ms *InMemoryStorage) Save(ctx context.Context, session *Session) error {
-//	sessionData, err := json.Marshal(session)
-//	if err != nil {
-//		return errors.Wrap(err, "session marshal")
-//	}
-//	ms.mx.Lock()
-//	ms.storage[session.ChatID] = sessionData
-//	ms.mx.Unlock()
-//	return nil
-//}
-//
-//func (ms *InMemoryStorage) Delete(ctx context.Context, session *Session) error {
-//	ms.mx.Lock()
-//	defer ms.mx.Unlock()
-//	delete(ms.storage, session.ChatID)
-//	return nil
-//}
+func NewInMemoryStorage() *InMemoryStorage {
+	return &InMemoryStorage{storage: make(map[int][]byte)}
+}
+
+func (ms *InMemoryStorage) Get(ctx context.Context, chatID int) (*Session, error) {
+	ms.mx.RLock()
+	sessionData, ok := ms.storage[chatID]
+	ms.mx.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+	doc := bson.M{}
+	err := bson.Unmarshal(sessionData, doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "session data unmarshal")
+	}
+	data, err := migrateSession(doc)
+	if err != nil {
+		return nil, err
+	}
+	session, err := data.ToSession()
+	return session, errors.Wrapf(err, "cannot build session from stored representation %s", data)
+}
+
+func (ms *InMemoryStorage) Save(ctx context.Context, session *Session) error {
+	data := NewSessionInMongo(session)
+	data.Version++
+	sessionData, err := bson.Marshal(data)
+	if err != nil {
+		return errors.Wrap(err, "session marshal")
+	}
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	var storedVersion int
+	if storedData, ok := ms.storage[session.ChatID]; ok {
+		stored := &SessionInMongo{}
+		err := bson.Unmarshal(storedData, stored)
+		if err != nil {
+			return errors.Wrap(err, "stored session unmarshal")
+		}
+		storedVersion = stored.Version
+	}
+	if storedVersion != session.Version {
+		return &ConflictError{ChatID: session.ChatID, Version: session.Version}
+	}
+	ms.storage[session.ChatID] = sessionData
+	session.Version = data.Version
+	return nil
+}
+
+func (ms *InMemoryStorage) Delete(ctx context.Context, session *Session) error {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	delete(ms.storage, session.ChatID)
+	return nil
+}
+
+func (ms *InMemoryStorage) DeleteInactive(ctx context.Context, before time.Time) error {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	for chatID, sessionData := range ms.storage {
+		stored := &SessionInMongo{}
+		err := bson.Unmarshal(sessionData, stored)
+		if err != nil {
+			return errors.Wrap(err, "stored session unmarshal")
+		}
+		if !stored.LastActivity.IsZero() && stored.LastActivity.Before(before) {
+			delete(ms.storage, chatID)
+		}
+	}
+	return nil
+}
 
 type MongoStorage struct {
 	client *mongo.Client
@@ -226,23 +420,61 @@ func NewMongoStorage(database, collection, user, password, host string, port, ti
 	return &MongoStorage{client: client}, nil
 }
 
+// EnsureMongoIndexes creates the unique chat_id index of the sessions collection, which Save relies on
+// to detect concurrently inserted sessions. It fails if the collection already has duplicated sessions of a chat.
+func EnsureMongoIndexes(database, collection, user, password, host string, port, timeout int) error {
+	session, err := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:    []string{fmt.Sprintf("%s:%d", host, port)},
+		Database: database,
+		Username: user,
+		Password: password,
+		Timeout:  time.Duration(timeout) * time.Second,
+	})
+	if err != nil {
+		return errors.Wrap(err, "mongo dial")
+	}
+	defer session.Close()
+	err = session.DB(database).C(collection).EnsureIndex(mgo.Index{Key: []string{"chat_id"}, Unique: true})
+	return errors.Wrap(err, "ensure chat_id index")
+}
+
 func (ms *MongoStorage) Get(ctx context.Context, chatID int) (*Session, error) {
-	data := &SessionInMongo{}
-	err := ms.client.FindOne(ctx, bson.M{"chat_id": chatID}, data)
+	doc := bson.M{}
+	err := ms.client.FindOne(ctx, bson.M{"chat_id": chatID}, &doc)
 	if err == mgo.ErrNotFound {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, errors.Wrap(err, "mongo find one")
 	}
+	data, err := migrateSession(doc)
+	if err != nil {
+		return nil, err
+	}
 	session, err := data.ToSession()
 	return session, errors.Wrapf(err, "cannot build session from mongo representation %s", data)
 }
 
+// Save replaces the stored session only if it has the same version as the saving one.
+// Sessions without version, new ones or saved before versioning was introduced, are upserted,
+// a concurrently inserted session is detected by the unique index on chat_id, see EnsureMongoIndexes.
 func (ms *MongoStorage) Save(ctx context.Context, session *Session) error {
 	sessionData := NewSessionInMongo(session)
-	err := ms.client.UpsertRetry(ctx, bson.M{"chat_id": session.ChatID}, sessionData)
-	return errors.Wrap(err, "mongo upsert")
+	sessionData.Version++
+	query := bson.M{"chat_id": session.ChatID, "version": session.Version}
+	if session.Version == 0 {
+		query["version"] = bson.M{"$exists": false}
+	}
+	change := mgo.Change{Update: sessionData, Upsert: session.Version == 0}
+	err := ms.client.FindAndModify(ctx, query, "chat_id", change, &SessionInMongo{})
+	if err == mgo.ErrNotFound || mgo.IsDup(errors.Cause(err)) {
+		return &ConflictError{ChatID: session.ChatID, Version: session.Version}
+	}
+	if err != nil {
+		return errors.Wrap(err, "mongo find and modify")
+	}
+	session.Version = sessionData.Version
+	return nil
 }
 
 func (ms *MongoStorage) Delete(ctx context.Context, session *Session) error {
@@ -250,33 +482,61 @@ func (ms *MongoStorage) Delete(ctx context.Context, session *Session) error {
 	return errors.Wrap(err, "mongo remove")
 }
 
+// DeleteInactive keeps sessions without a recorded activity, e.g. saved before it was tracked,
+// they get the last activity on the next request.
+func (ms *MongoStorage) DeleteInactive(ctx context.Context, before time.Time) error {
+	_, err := ms.client.Remove(ctx, bson.M{"last_activity": bson.M{"$lt": before, "$gt": time.Time{}}})
+	return errors.Wrap(err, "mongo remove")
+}
+
 type IntentInMongo struct {
 	Handler string   `bson:"handler"`
 	Words   []string `bson:"words"`
 }
 
 type SessionInMongo struct {
-	SessionID    string                            `bson:"session_id"`
-	ChatID       int                               `bson:"chat_id"`
-	LocalIntents []*IntentInMongo                  `bson:"local_intents"`
-	LastPage     string                            `bson:"last_page"`
-	InputHandler string                            `bson:"input_handler"`
-	PagesStates  map[string]map[string]interface{} `bson:"pages_states"`
-	GlobalState  map[string]interface{}            `bson:"global_states"`
+	SessionID     string                            `bson:"session_id"`
+	ChatID        int                               `bson:"chat_id"`
+	Version       int                               `bson:"version"`
+	SchemaVersion int                               `bson:"schema_version"`
+	LocalIntents  []*IntentInMongo                  `bson:"local_intents"`
+	LastPage      string                            `bson:"last_page"`
+	InputHandler  string                            `bson:"input_handler"`
+	PagesStates   map[string]map[string]interface{} `bson:"pages_states"`
+	GlobalState   map[string]interface{}            `bson:"global_states"`
+	LastActivity  time.Time                         `bson:"last_activity"`
+	History       []string                          `bson:"history"`
+	StoredButtons []*StoredButtonInMongo            `bson:"stored_buttons"`
+}
+
+type StoredButtonInMongo struct {
+	Key     string `bson:"key"`
+	Handler string `bson:"handler"`
 }
 
 func NewSessionInMongo(session *Session) *SessionInMongo {
 	sm := new(SessionInMongo)
 	sm.SessionID = session.ID
 	sm.ChatID = session.ChatID
+	sm.Version = session.Version
+	sm.SchemaVersion = CurrentSessionSchemaVersion()
 	sm.InputHandler = session.InputHandler.Encode()
 	sm.LastPage = session.LastPage.Encode()
 	sm.GlobalState = session.GlobalState
 	sm.PagesStates = session.PagesStates
+	sm.LastActivity = session.LastActivity
+	sm.History = make([]string, len(session.History))
+	for i, screen := range session.History {
+		sm.History[i] = screen.Encode()
+	}
 	sm.LocalIntents = make([]*IntentInMongo, len(session.LocalIntents))
 	for i, intent := range session.LocalIntents {
 		sm.LocalIntents[i] = &IntentInMongo{intent.Handler.Encode(), intent.Words}
 	}
+	sm.StoredButtons = make([]*StoredButtonInMongo, len(session.StoredButtons))
+	for i, button := range session.StoredButtons {
+		sm.StoredButtons[i] = &StoredButtonInMongo{button.Key, button.Handler.Encode()}
+	}
 	return sm
 }
 
@@ -291,29 +551,55 @@ func (sm *SessionInMongo) ToSession() (*Session, error) {
 	if sm.SessionID == "" {
 		return nil, errors.New("session_id field doesn't present")
 	}
-	model := &Session{ID: sm.SessionID, ChatID: sm.ChatID, PagesStates: sm.PagesStates, GlobalState: sm.GlobalState}
-	localIntents := make([]*Intent, len(sm.LocalIntents))
+	model := &Session{ID: sm.SessionID, ChatID: sm.ChatID, Version: sm.Version, PagesStates: sm.PagesStates,
+		GlobalState: sm.GlobalState, LastActivity: sm.LastActivity}
+	// urls that can't be parsed anymore, e.g after renaming, are dropped instead of failing the whole session
+	logger := gLogger.WithField("chat_id", sm.ChatID)
+	localIntents := make([]*Intent, 0, len(sm.LocalIntents))
 	for i, intentData := range sm.LocalIntents {
 		intent, err := NewIntentStrHandler(intentData.Handler, intentData.Words)
 		if err != nil {
-			return nil, errors.Wrapf(err, "cannot build local intent %d from storage data", i)
+			logger.Warnf("Skip bad local intent %d: %s", i, err)
+			continue
 		}
-		localIntents[i] = intent
+		localIntents = append(localIntents, intent)
 	}
 	var inputHandlerURL *URL
 	if sm.InputHandler != "" {
 		var err error
 		inputHandlerURL, err = NewURLFromStr(sm.InputHandler)
 		if err != nil {
-			return nil, errors.Wrap(err, "storage contains bad input handler")
+			logger.Warnf("Reset bad input handler %s: %s", sm.InputHandler, err)
+			inputHandlerURL = nil
 		}
 	}
 	lastPageURL, err := NewURLFromStr(sm.LastPage)
 	if err != nil {
-		return nil, errors.Wrap(err, "storage contains bad last page")
+		logger.Warnf("Reset bad last page %s to the default one: %s", sm.LastPage, err)
+		lastPageURL = DefaultPageURL
+	}
+	history := make([]*URL, 0, len(sm.History))
+	for _, rawurl := range sm.History {
+		screen, err := NewURLFromStr(rawurl)
+		if err != nil {
+			logger.Warnf("Skip bad history url %s: %s", rawurl, err)
+			continue
+		}
+		history = append(history, screen)
+	}
+	storedButtons := make([]*StoredButton, 0, len(sm.StoredButtons))
+	for _, buttonData := range sm.StoredButtons {
+		handler, err := NewURLFromStr(buttonData.Handler)
+		if err != nil {
+			logger.Warnf("Skip bad stored button %s: %s", buttonData.Handler, err)
+			continue
+		}
+		storedButtons = append(storedButtons, &StoredButton{Key: buttonData.Key, Handler: handler})
 	}
 	model.LocalIntents = localIntents
 	model.InputHandler = inputHandlerURL
 	model.LastPage = lastPageURL
+	model.History = history
+	model.StoredButtons = storedButtons
 	return model, nil
 }
@@ -0,0 +1,101 @@
+package core
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"github.com/gazoon/bot_libs/logging"
+	"github.com/pkg/errors"
+)
+
+const (
+	deepLinkArgDivider = "_"
+)
+
+var (
+	startPayloadRegexp = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+)
+
+// DeepLink maps a telegram start payload, e.g t.me/bot?start=show_<arg>, to the page url.
+// Only links with non-empty ArgParam accept an argument, it's passed to the url in that param.
+type DeepLink struct {
+	Name     string
+	URL      *URL
+	ArgParam string
+}
+
+func NewDeepLink(name, rawurl, argParam string) (*DeepLink, error) {
+	if name == "" || strings.Contains(name, deepLinkArgDivider) {
+		return nil, errors.Errorf("bad deep link name %q", name)
+	}
+	u, err := NewURLFromStr(rawurl)
+	if err != nil {
+		return nil, errors.Wrapf(err, "deep link %s url", name)
+	}
+	if u.IsRelative() {
+		return nil, errors.Errorf("deep link %s url must be absolute", name)
+	}
+	return &DeepLink{Name: name, URL: u, ArgParam: argParam}, nil
+}
+
+func (dl DeepLink) String() string {
+	return logging.ObjToString(&dl)
+}
+
+type DeepLinks struct {
+	links map[string]*DeepLink
+}
+
+func NewDeepLinks(links []*DeepLink) *DeepLinks {
+	mapping := make(map[string]*DeepLink, len(links))
+	for _, link := range links {
+		mapping[link.Name] = link
+	}
+	return &DeepLinks{links: mapping}
+}
+
+// Resolve returns url the /start command payload leads to.
+// The start command without payload or with a payload that isn't allowed leads to the default page.
+func (dls *DeepLinks) Resolve(ctx context.Context, payload string) *URL {
+	if payload == "" {
+		return DefaultPageURL
+	}
+	logger := logging.FromContextAndBase(ctx, gLogger).WithField("start_payload", payload)
+	u, err := dls.resolvePayload(payload)
+	if err != nil {
+		logger.Warnf("Cannot resolve deep link, go to the default page: %s", err)
+		return DefaultPageURL
+	}
+	logger.Infof("Deep link leads to %s", u.Encode())
+	return u
+}
+
+func (dls *DeepLinks) resolvePayload(payload string) (*URL, error) {
+	if !startPayloadRegexp.MatchString(payload) {
+		return nil, errors.New("payload contains forbidden characters")
+	}
+	parts := strings.SplitN(payload, deepLinkArgDivider, 2)
+	link, ok := dls.links[parts[0]]
+	if !ok {
+		return nil, errors.Errorf("unknown deep link %s", parts[0])
+	}
+	var arg string
+	if len(parts) == 2 {
+		arg = parts[1]
+	}
+	if link.ArgParam == "" && arg != "" {
+		return nil, errors.Errorf("deep link %s doesn't accept an argument", link.Name)
+	}
+	if link.ArgParam != "" && arg == "" {
+		return nil, errors.Errorf("deep link %s requires an argument", link.Name)
+	}
+	params := make(map[string]string, len(link.URL.Params)+1)
+	for k, v := range link.URL.Params {
+		params[k] = v
+	}
+	if link.ArgParam != "" {
+		params[link.ArgParam] = arg
+	}
+	return NewURL(link.URL.Page, link.URL.Action, params), nil
+}
@@ -1,6 +1,8 @@
 package core
 
 import (
+	"strings"
+
 	"github.com/gazoon/bot_libs/logging"
 	"github.com/pkg/errors"
 )
@@ -25,3 +27,19 @@ func NewIntentStrHandler(handlerStr string, words []string) (*Intent, error) {
 func (i Intent) String() string {
 	return logging.ObjToString(&i)
 }
+
+// MatchIntent returns handler of the first intent one of whose words equals the text.
+func MatchIntent(intents []*Intent, text string) *URL {
+	text = strings.ToLower(strings.TrimSpace(text))
+	if text == "" {
+		return nil
+	}
+	for _, intent := range intents {
+		for _, word := range intent.Words {
+			if strings.ToLower(word) == text {
+				return intent.Handler
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package core
+
+import (
+	"github.com/globalsign/mgo/bson"
+	"github.com/pkg/errors"
+)
+
+// SessionMigration upgrades a stored session document to the next schema version.
+type SessionMigration func(doc bson.M) error
+
+// sessionMigrations[i] converts a session document from schema version i to i+1.
+// Append a migration when the stored representation changes or a page or an action is renamed,
+// existing migrations must never be changed or removed.
+var sessionMigrations = []SessionMigration{
+	// v0 -> v1: sessions saved before schema versioning could have no states
+	func(doc bson.M) error {
+		for _, key := range []string{"pages_states", "global_states"} {
+			if _, ok := doc[key].(bson.M); !ok {
+				doc[key] = bson.M{}
+			}
+		}
+		return nil
+	},
+}
+
+func CurrentSessionSchemaVersion() int {
+	return len(sessionMigrations)
+}
+
+// RenamePageMigration rewrites all session urls leading to the old page and moves the page state.
+func RenamePageMigration(oldName, newName string) SessionMigration {
+	return func(doc bson.M) error {
+		if states, ok := doc["pages_states"].(bson.M); ok {
+			if state, ok := states[oldName]; ok {
+				states[newName] = state
+				delete(states, oldName)
+			}
+		}
+		return rewriteSessionURLs(doc, func(u *URL) {
+			if u.Page == oldName {
+				u.Page = newName
+			}
+		})
+	}
+}
+
+// RenameActionMigration rewrites all session urls leading to the old action of the page.
+func RenameActionMigration(pageName, oldAction, newAction string) SessionMigration {
+	return func(doc bson.M) error {
+		return rewriteSessionURLs(doc, func(u *URL) {
+			if u.Page == pageName && u.Action == oldAction {
+				u.Action = newAction
+			}
+		})
+	}
+}
+
+func rewriteSessionURLs(doc bson.M, rewrite func(u *URL)) error {
+	rewriteStr := func(value interface{}) interface{} {
+		rawurl, ok := value.(string)
+		if !ok || rawurl == "" {
+			return value
+		}
+		u, err := NewURLFromStr(rawurl)
+		if err != nil {
+			// unparsable urls are reset when the session is built
+			return value
+		}
+		rewrite(u)
+		return u.Encode()
+	}
+	for _, key := range []string{"last_page", "input_handler"} {
+		if value, ok := doc[key]; ok {
+			doc[key] = rewriteStr(value)
+		}
+	}
+	if history, ok := doc["history"].([]interface{}); ok {
+		for i, value := range history {
+			history[i] = rewriteStr(value)
+		}
+	}
+	if intents, ok := doc["local_intents"].([]interface{}); ok {
+		for _, value := range intents {
+			intent, ok := value.(bson.M)
+			if !ok {
+				continue
+			}
+			intent["handler"] = rewriteStr(intent["handler"])
+		}
+	}
+	if buttons, ok := doc["stored_buttons"].([]interface{}); ok {
+		for _, value := range buttons {
+			button, ok := value.(bson.M)
+			if !ok {
+				continue
+			}
+			button["handler"] = rewriteStr(button["handler"])
+		}
+	}
+	return nil
+}
+
+func migrateSession(doc bson.M) (*SessionInMongo, error) {
+	version := docInt(doc["schema_version"])
+	for ; version < len(sessionMigrations); version++ {
+		err := sessionMigrations[version](doc)
+		if err != nil {
+			return nil, errors.Wrapf(err, "session migration from schema version %d", version)
+		}
+		doc["schema_version"] = version + 1
+	}
+	data, err := bson.Marshal(doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "migrated session marshal")
+	}
+	sm := &SessionInMongo{}
+	err = bson.Unmarshal(data, sm)
+	return sm, errors.Wrap(err, "migrated session unmarshal")
+}
+
+func docInt(value interface{}) int {
+	switch v := value.(type) {
+	case int:
+		return v
+	case int64:
+		return int(v)
+	case float64:
+		return int(v)
+	}
+	return 0
+}
@@ -52,16 +52,20 @@ func (b Button) String() string {
 
 type Iterator struct {
 	messenger  messenger.Messenger
+	signer     *core.URLSigner
 	req        *core.Request
 	initScript []*Command
 	page       *BasePage
 	logger     *log.Entry
 }
 
-func NewIterator(req *core.Request, currentPage *BasePage, script []*Command, messenger messenger.Messenger) *Iterator {
+func NewIterator(req *core.Request, currentPage *BasePage, script []*Command, messenger messenger.Messenger,
+	signer *core.URLSigner) *Iterator {
+
 	logger := logging.FromContextAndBase(req.Ctx, gLogger)
 	logger = logger.WithField("iteration_page", currentPage.Name)
-	return &Iterator{req: req, page: currentPage, messenger: messenger, initScript: script, logger: logger}
+	return &Iterator{req: req, page: currentPage, messenger: messenger, signer: signer, initScript: script,
+		logger: logger}
 }
 
 func (iter *Iterator) sendText(args interface{}) error {
@@ -71,29 +75,41 @@ func (iter *Iterator) sendText(args interface{}) error {
 	}
 	req := iter.req
 	msgID, err := iter.messenger.SendText(req.Ctx, req.ChatID, text)
-	iter.saveSentMsgID(msgID)
-	return errors.Wrap(err, "messenger send text")
+	if err != nil {
+		return errors.Wrap(err, "messenger send text")
+	}
+	return iter.saveSentMsgID(msgID)
 }
 
-func (iter *Iterator) saveSentMsgID(msgID int) {
+func (iter *Iterator) saveSentMsgID(msgID int) error {
 	req := iter.req
-	if req.SaveSentMsgIDs {
-		iter.page.StoreSentMsgID(req, msgID)
+	if !req.SaveSentMsgIDs {
+		return nil
 	}
+	err := iter.page.StoreSentMsgID(req, msgID)
+	return errors.Wrap(err, "store sent msg id")
 }
 
 func (iter *Iterator) saveUserMsgID(args interface{}) error {
 	req := iter.req
-	iter.page.StoreUserMsgID(req, req.MsgID)
-	return nil
+	err := iter.page.StoreUserMsgID(req, req.MsgID)
+	return errors.Wrap(err, "store user msg id")
 }
 
+// setSaveSentMsgIDs accepts a flag or a page state key to save the ids under.
 func (iter *Iterator) setSaveSentMsgIDs(args interface{}) error {
-	flagValue, ok := args.(bool)
-	if !ok {
-		return errors.Errorf("expected bool args, got: %v", flagValue)
+	switch value := args.(type) {
+	case bool:
+		iter.req.SaveSentMsgIDs = value
+	case string:
+		if value == "" {
+			return errors.New("empty state key for sent msg ids")
+		}
+		iter.req.SaveSentMsgIDs = true
+		iter.req.SentMsgIDsKey = value
+	default:
+		return errors.Errorf("expected bool or string args, got: %v", args)
 	}
-	iter.req.SaveSentMsgIDs = flagValue
 	return nil
 }
 
@@ -124,7 +140,7 @@ func (iter *Iterator) sendTextWithButtons(args interface{}) error {
 	for i, button := range buttons {
 		var payload string
 		if button.Handler != nil {
-			payload = button.Handler.Encode()
+			payload = iter.signer.ButtonPayload(req.Session, button.Handler)
 		} else {
 			payload = button.Text
 		}
@@ -139,8 +155,10 @@ func (iter *Iterator) sendTextWithButtons(args interface{}) error {
 	iter.logger.WithFields(log.Fields{"text": text, "buttons": messengerButtons}).
 		Info("Send text with connected buttons to the messenger")
 	msgID, err := iter.messenger.SendTextWithButtons(req.Ctx, req.ChatID, text, messengerButtons...)
-	iter.saveSentMsgID(msgID)
-	return errors.Wrap(err, "messenger send text with buttons")
+	if err != nil {
+		return errors.Wrap(err, "messenger send text with buttons")
+	}
+	return iter.saveSentMsgID(msgID)
 }
 
 func (iter *Iterator) setInputHandler(args interface{}) error {
@@ -315,13 +333,17 @@ func processTextArgs(textArgs interface{}) (string, error) {
 	if !ok {
 		lines = []interface{}{textArgs}
 	}
-	texts := make([]string, len(lines))
-	for i, line := range lines {
+	texts := make([]string, 0, len(lines))
+	for _, line := range lines {
+		// lines with a false condition and without else branch are skipped
+		if line == nil {
+			continue
+		}
 		text, ok := line.(string)
 		if !ok {
 			return "", errors.Errorf("args must be a string or list of strings %v", line)
 		}
-		texts[i] = text
+		texts = append(texts, text)
 	}
 	wholeText := strings.Join(texts, "\n")
 	return wholeText, nil
@@ -2,8 +2,10 @@ package page
 
 import (
 	"bytes"
+	"encoding/json"
 	"io/ioutil"
 	"path"
+	"regexp"
 	"strconv"
 	"strings"
 	templ "text/template"
@@ -26,8 +28,11 @@ const (
 	evaluationMarker   = "$"
 	redirectCmd        = "redirect"
 	gotoCmd            = "goto"
+	sentMsgIDsKey      = "sent_msg_ids"
 )
 
+var commandNameRegexp = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
 func parseYAML(data []byte, val interface{}) error {
 	err := yaml.Unmarshal(data, val)
 	return err
@@ -38,6 +43,11 @@ type Page interface {
 	Init(builder *PagesBuilder) error
 	HandleIntent(req *core.Request) (*core.URL, error)
 	Enter(req *core.Request) (*core.URL, error)
+	GetRequiredRoles() []string
+	GetCommands() []*BotCommand
+	GetGlobalIntents() []*core.Intent
+	IsTransient(u *core.URL) bool
+	HasAction(action string) bool
 }
 
 type SequenceItem struct {
@@ -45,28 +55,70 @@ type SequenceItem struct {
 	Value interface{}
 }
 
+// BotCommand is a slash command, e.g /new, that leads the user to the page.
+type BotCommand struct {
+	Name        string
+	Description string
+	Handler     *core.URL
+}
+
+func (bc BotCommand) String() string {
+	return logging.ObjToString(&bc)
+}
+
 type Controller func(req *core.Request) (map[string]interface{}, *core.URL, error)
 
+type IntentStructure struct {
+	HandlerURLStr string   `json:"handler"`
+	Words         []string `json:"words"`
+}
+
 type PageStructure struct {
-	Intents []*struct {
-		HandlerURLStr string   `json:"handler"`
-		Words         []string `json:"words"`
-	} `json:"intents"`
+	Intents       []*IntentStructure `json:"intents"`
+	GlobalIntents []*IntentStructure `json:"global_intents"`
+	Commands      []*struct {
+		Name          string `json:"name"`
+		HandlerURLStr string `json:"handler"`
+		Description   string `json:"description"`
+	} `json:"commands"`
 	Actions     map[string][]map[string]interface{} `json:"actions"`
 	Config      map[string]interface{}              `json:"config"`
 	EntryAction string                              `json:"entry_action"`
+	Access      Roles                               `json:"access"`
+	// actions that don't get to the navigation history, e.g ones that can't be repeated
+	TransientActions []string `json:"transient_actions"`
+}
+
+// Roles can be declared in the page file either as a single role or as a list of roles.
+type Roles []string
+
+func (r *Roles) UnmarshalJSON(data []byte) error {
+	var role string
+	if err := json.Unmarshal(data, &role); err == nil {
+		*r = Roles{role}
+		return nil
+	}
+	var roles []string
+	err := json.Unmarshal(data, &roles)
+	if err != nil {
+		return errors.Wrap(err, "access must be a string or list of strings")
+	}
+	*r = roles
+	return nil
 }
 
 type PagesBuilder struct {
 	messenger         messenger.Messenger
+	signer            *core.URLSigner
 	fileExtension     string
 	pagesFolder       string
 	fileContentParser func(data []byte, val interface{}) error
+	stateCodec        StateCodec
 }
 
-func NewPagesBuilder(messenger messenger.Messenger, folder string) *PagesBuilder {
-	return &PagesBuilder{messenger: messenger, fileExtension: yamlFileExtension, pagesFolder: folder,
-		fileContentParser: parseYAML}
+func NewPagesBuilder(messenger messenger.Messenger, signer *core.URLSigner, folder string) *PagesBuilder {
+	return &PagesBuilder{messenger: messenger, signer: signer, fileExtension: yamlFileExtension, pagesFolder: folder,
+		fileContentParser: parseYAML, stateCodec: JSONStateCodec{}}
 }
 
 func (pb *PagesBuilder) NewBasePage(name string, globalController Controller, actionControllers map[string]Controller) (*BasePage, error) {
@@ -93,24 +145,52 @@ func (pb *PagesBuilder) NewBasePage(name string, globalController Controller, ac
 	logger := logging.NewObjectLogger("pages", log.Fields{"page": name})
 
 	page := &BasePage{
-		Name: name, messenger: pb.messenger, globalController: globalController, actionControllers: actionControllers,
-		ParsedPage: parsedPage, actionViews: actionViews, ObjectLogger: logger, entryAction: parsedPage.EntryAction,
-	}
-
-	page.Intents, err = page.buildIntents()
+		Name:              name,
+		messenger:         pb.messenger,
+		signer:            pb.signer,
+		stateCodec:        pb.stateCodec,
+		globalController:  globalController,
+		actionControllers: actionControllers,
+		ParsedPage:        parsedPage,
+		actionViews:       actionViews,
+		ObjectLogger:      logger,
+		entryAction:       parsedPage.EntryAction,
+	}
+
+	page.Intents, err = page.buildIntents(parsedPage.Intents)
 	if err != nil {
 		return nil, errors.Wrap(err, "cannot build intents")
 	}
+	page.GlobalIntents, err = page.buildIntents(parsedPage.GlobalIntents)
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot build global intents")
+	}
+	page.transientActions = make(map[string]bool, len(parsedPage.TransientActions))
+	for _, action := range parsedPage.TransientActions {
+		page.transientActions[action] = true
+	}
+	page.Commands, err = page.buildCommands()
+	if err != nil {
+		return nil, errors.Wrap(err, "cannot build commands")
+	}
 	return page, nil
 }
 
 func (pb *PagesBuilder) InstantiatePages(pages ...Page) (map[string]Page, error) {
 	registry := make(map[string]Page, len(pages))
+	commandPages := make(map[string]string)
 	for _, p := range pages {
 		err := p.Init(pb)
 		if err != nil {
 			return nil, errors.Wrapf(err, "page %s initialization failed", reflect.TypeOf(p))
 		}
+		for _, command := range p.GetCommands() {
+			if otherPage, ok := commandPages[command.Name]; ok {
+				return nil, errors.Errorf("command %s declared in both %s and %s pages", command.Name, otherPage,
+					p.GetName())
+			}
+			commandPages[command.Name] = p.GetName()
+		}
 		registry[p.GetName()] = p
 	}
 	return registry, nil
@@ -120,13 +200,18 @@ type BasePage struct {
 	*logging.ObjectLogger
 	Name              string
 	messenger         messenger.Messenger
+	signer            *core.URLSigner
+	stateCodec        StateCodec
 	globalController  Controller
 	actionControllers map[string]Controller
 
-	ParsedPage  *PageStructure
-	Intents     []*core.Intent
-	actionViews map[string][]*SequenceItem
-	entryAction string
+	ParsedPage       *PageStructure
+	Intents          []*core.Intent
+	GlobalIntents    []*core.Intent
+	Commands         []*BotCommand
+	actionViews      map[string][]*SequenceItem
+	entryAction      string
+	transientActions map[string]bool
 }
 
 func retrieveActions(parsedPage *PageStructure) (map[string][]*SequenceItem, error) {
@@ -196,11 +281,40 @@ func (bp *BasePage) GetName() string {
 	return bp.Name
 }
 
+func (bp *BasePage) GetCommands() []*BotCommand {
+	return bp.Commands
+}
+
+func (bp *BasePage) GetRequiredRoles() []string {
+	return bp.ParsedPage.Access
+}
+
 func (bp *BasePage) HandleIntent(req *core.Request) (*core.URL, error) {
 	bp.GetLogger(req.Ctx).Info(req.Intents)
 	return core.NotFoundPageURL, nil
 }
 
+func (bp *BasePage) GetGlobalIntents() []*core.Intent {
+	return bp.GlobalIntents
+}
+
+func (bp *BasePage) HasAction(action string) bool {
+	if action == "" {
+		return true
+	}
+	_, viewExists := bp.actionViews[action]
+	_, controllerExists := bp.actionControllers[action]
+	return viewExists || controllerExists
+}
+
+func (bp *BasePage) IsTransient(u *core.URL) bool {
+	action := u.Action
+	if action == "" {
+		action = bp.entryAction
+	}
+	return bp.transientActions[action]
+}
+
 func (bp *BasePage) ActionViews() []string {
 	names := make([]string, 0, len(bp.actionViews))
 	for k := range bp.actionViews {
@@ -222,10 +336,9 @@ func (bp *BasePage) buildURL(action string, params map[string]string) *core.URL
 	return core.NewURL(bp.Name, action, params)
 }
 
-func (bp *BasePage) buildIntents() ([]*core.Intent, error) {
-	parsedPage := bp.ParsedPage
-	intents := make([]*core.Intent, len(parsedPage.Intents))
-	for i, item := range parsedPage.Intents {
+func (bp *BasePage) buildIntents(items []*IntentStructure) ([]*core.Intent, error) {
+	intents := make([]*core.Intent, len(items))
+	for i, item := range items {
 		intent, err := core.NewIntentStrHandler(item.HandlerURLStr, item.Words)
 		if err != nil {
 			return nil, err
@@ -236,42 +349,66 @@ func (bp *BasePage) buildIntents() ([]*core.Intent, error) {
 	return intents, nil
 }
 
-func (bp *BasePage) appendInState(req *core.Request, key string, newValue interface{}) {
-	state := bp.GetState(req)
-	msgIDs, _ := state[key].([]interface{})
-	msgIDs = append(msgIDs, newValue)
-	state[key] = msgIDs
-	bp.SetState(req, state)
+func (bp *BasePage) buildCommands() ([]*BotCommand, error) {
+	parsedPage := bp.ParsedPage
+	commands := make([]*BotCommand, len(parsedPage.Commands))
+	for i, item := range parsedPage.Commands {
+		if !commandNameRegexp.MatchString(item.Name) {
+			return nil, errors.Errorf("bad command name %q", item.Name)
+		}
+		if item.Description == "" {
+			return nil, errors.Errorf("command %s without description", item.Name)
+		}
+		handler, err := bp.parseURL(item.HandlerURLStr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "incorrect command %s handler url", item.Name)
+		}
+		commands[i] = &BotCommand{Name: item.Name, Description: item.Description, Handler: handler}
+	}
+	return commands, nil
+}
+
+func (bp *BasePage) appendIntInState(req *core.Request, key string, newValue int) error {
+	var values []int
+	err := bp.GetStateAs(req, key, &values)
+	if err != nil {
+		return err
+	}
+	return bp.SetStateAs(req, key, append(values, newValue))
 }
 
 func (bp *BasePage) getIntListFromState(req *core.Request, key string) []int {
-	state := bp.GetState(req)
-	array, _ := state[key].([]interface{})
-	var msgIDs []int
-	for _, value := range array {
-		msgID, ok := value.(int)
-		if !ok {
-			continue
-		}
-		msgIDs = append(msgIDs, msgID)
+	var values []int
+	err := bp.GetStateAs(req, key, &values)
+	if err != nil {
+		bp.GetLogger(req.Ctx).Warnf("Cannot get int list from the state: %s", err)
 	}
-	return msgIDs
+	return values
 }
 
 func (bp *BasePage) GetSentMsgIDs(req *core.Request) []int {
-	return bp.getIntListFromState(req, "sent_msg_ids")
+	return bp.getIntListFromState(req, sentMsgIDsKey)
+}
+
+// GetSentMsgIDsByKey returns ids saved by the save_sent_msg_ids command called with a state key.
+func (bp *BasePage) GetSentMsgIDsByKey(req *core.Request, key string) []int {
+	return bp.getIntListFromState(req, key)
 }
 
 func (bp *BasePage) GetUserMsgIDs(req *core.Request) []int {
 	return bp.getIntListFromState(req, "user_msg_ids")
 }
 
-func (bp *BasePage) StoreSentMsgID(req *core.Request, msgID int) {
-	bp.appendInState(req, "sent_msg_ids", msgID)
+func (bp *BasePage) StoreSentMsgID(req *core.Request, msgID int) error {
+	key := req.SentMsgIDsKey
+	if key == "" {
+		key = sentMsgIDsKey
+	}
+	return bp.appendIntInState(req, key, msgID)
 }
 
-func (bp *BasePage) StoreUserMsgID(req *core.Request, msgID int) {
-	bp.appendInState(req, "user_msg_ids", msgID)
+func (bp *BasePage) StoreUserMsgID(req *core.Request, msgID int) error {
+	return bp.appendIntInState(req, "user_msg_ids", msgID)
 }
 
 func (bp *BasePage) GetState(req *core.Request) map[string]interface{} {
@@ -288,6 +425,10 @@ func (bp *BasePage) UpdateState(req *core.Request, key string, value interface{}
 	bp.SetState(req, state)
 }
 
+func (bp *BasePage) DeleteStateKey(req *core.Request, key string) {
+	delete(bp.GetState(req), key)
+}
+
 func (bp *BasePage) ClearState(req *core.Request) {
 	delete(req.Session.PagesStates, bp.Name)
 }
@@ -382,8 +523,12 @@ func (bp *BasePage) parseButtonsArg(buttonsData interface{}) ([]*Button, error)
 	if !ok {
 		return nil, errors.Errorf("expected array, not %v", buttonsData)
 	}
-	buttons := make([]*Button, len(buttonsArray))
-	for i, buttonData := range buttonsArray {
+	buttons := make([]*Button, 0, len(buttonsArray))
+	for _, buttonData := range buttonsArray {
+		// conditional buttons without else branch are skipped
+		if buttonData == nil {
+			continue
+		}
 		parsedButton := &struct {
 			Text    string   `mapstructure:"text"`
 			Handler string   `mapstructure:"handler"`
@@ -400,7 +545,7 @@ func (bp *BasePage) parseButtonsArg(buttonsData interface{}) ([]*Button, error)
 				return nil, errors.Wrapf(err, "incorrect button handler url %s", parsedButton.Handler)
 			}
 		}
-		buttons[i] = &Button{Text: parsedButton.Text, Intents: parsedButton.Intents, Handler: handlerURL}
+		buttons = append(buttons, &Button{Text: parsedButton.Text, Intents: parsedButton.Intents, Handler: handlerURL})
 	}
 	return buttons, nil
 }
@@ -420,6 +565,9 @@ func (bp *BasePage) transformURLs(commandName string, args interface{}) (interfa
 		}
 		return u, nil
 	}
+	if commandName == ForeachCmd {
+		return bp.transformForeachURLs(args)
+	}
 	if commandName == SendButtonsCmd {
 		buttons, err := bp.parseButtonsArg(args)
 		if err != nil {
@@ -448,6 +596,34 @@ func (bp *BasePage) transformURLs(commandName string, args interface{}) (interfa
 	return argsWithButtons, nil
 }
 
+// transformForeachURLs transforms every value as args of the foreach function,
+// e.g results with their own buttons sent by send_text_with_buttons.
+func (bp *BasePage) transformForeachURLs(args interface{}) (interface{}, error) {
+	argsAsObject, ok := args.(map[string]interface{})
+	if !ok {
+		return args, nil
+	}
+	function, _ := argsAsObject["function"].(string)
+	values, ok := argsAsObject["values"].([]interface{})
+	if !ok {
+		return args, nil
+	}
+	transformedValues := make([]interface{}, len(values))
+	for i, value := range values {
+		var err error
+		transformedValues[i], err = bp.transformURLs(function, value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "foreach value %d", i)
+		}
+	}
+	transformedArgs := make(map[string]interface{}, len(argsAsObject))
+	for k, v := range argsAsObject {
+		transformedArgs[k] = v
+	}
+	transformedArgs["values"] = transformedValues
+	return transformedArgs, nil
+}
+
 func (bp *BasePage) parseURL(rawurl string) (*core.URL, error) {
 	u, err := core.NewURLFromStr(rawurl)
 	if err != nil {
@@ -463,7 +639,7 @@ func (bp *BasePage) executeScript(req *core.Request, script []*Command) error {
 	if len(script) == 0 {
 		return nil
 	}
-	iter := NewIterator(req, bp, script, bp.messenger)
+	iter := NewIterator(req, bp, script, bp.messenger, bp.signer)
 	err := iter.Run()
 	return errors.Wrap(err, "script iteration failed")
 }
@@ -0,0 +1,64 @@
+package page
+
+import (
+	"encoding/json"
+	"reminder/core"
+
+	"github.com/pkg/errors"
+)
+
+// StateCodec converts typed values to the representation kept in the page state and back.
+// The stored representation must survive the session storage encoding unchanged.
+type StateCodec interface {
+	Encode(value interface{}) (interface{}, error)
+	Decode(stored interface{}, out interface{}) error
+}
+
+// JSONStateCodec keeps values in their json representation: strings, float64 numbers, bools, arrays and objects.
+// Templates can still read such values, e.g $page_state.last_enter, and decoding restores ints and times.
+type JSONStateCodec struct{}
+
+func (c JSONStateCodec) Encode(value interface{}) (interface{}, error) {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, errors.Wrap(err, "state value marshal")
+	}
+	var stored interface{}
+	err = json.Unmarshal(data, &stored)
+	return stored, errors.Wrap(err, "state value unmarshal")
+}
+
+func (c JSONStateCodec) Decode(stored interface{}, out interface{}) error {
+	data, err := json.Marshal(stored)
+	if err != nil {
+		return errors.Wrap(err, "stored state value marshal")
+	}
+	err = json.Unmarshal(data, out)
+	return errors.Wrapf(err, "stored state value %v unmarshal", stored)
+}
+
+// GetStateAs decodes the page state value to out, which must be a pointer.
+// Out is left unchanged if there is no value for the key.
+func (bp *BasePage) GetStateAs(req *core.Request, key string, out interface{}) error {
+	stored, ok := bp.GetState(req)[key]
+	if !ok || stored == nil {
+		return nil
+	}
+	err := bp.stateCodec.Decode(stored, out)
+	return errors.Wrapf(err, "page state key %s", key)
+}
+
+func (bp *BasePage) SetStateAs(req *core.Request, key string, value interface{}) error {
+	stored, err := bp.stateCodec.Encode(value)
+	if err != nil {
+		return errors.Wrapf(err, "page state key %s", key)
+	}
+	bp.UpdateState(req, key, stored)
+	return nil
+}
+
+// DecodeState decodes the whole page state to out, a pointer to a struct, fields are matched by json tags.
+func (bp *BasePage) DecodeState(req *core.Request, out interface{}) error {
+	err := bp.stateCodec.Decode(bp.GetState(req), out)
+	return errors.Wrap(err, "page state")
+}
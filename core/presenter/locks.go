@@ -0,0 +1,43 @@
+package presenter
+
+import (
+	"sync"
+)
+
+type chatLock struct {
+	sync.Mutex
+	refs int
+}
+
+// chatLocks serializes requests processing within one chat, so concurrent handlers don't overwrite
+// each other's session, while requests of different chats are processed in parallel.
+type chatLocks struct {
+	mx    sync.Mutex
+	locks map[int]*chatLock
+}
+
+func newChatLocks() *chatLocks {
+	return &chatLocks{locks: make(map[int]*chatLock)}
+}
+
+func (cl *chatLocks) lock(chatID int) func() {
+	cl.mx.Lock()
+	l, ok := cl.locks[chatID]
+	if !ok {
+		l = &chatLock{}
+		cl.locks[chatID] = l
+	}
+	l.refs++
+	cl.mx.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+		cl.mx.Lock()
+		l.refs--
+		if l.refs == 0 {
+			delete(cl.locks, chatID)
+		}
+		cl.mx.Unlock()
+	}
+}
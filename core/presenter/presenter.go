@@ -4,6 +4,8 @@ import (
 	"context"
 	"reminder/core"
 	"reminder/core/page"
+	"sort"
+	"time"
 
 	"github.com/gazoon/bot_libs/logging"
 	"github.com/gazoon/bot_libs/messenger"
@@ -12,7 +14,8 @@ import (
 )
 
 const (
-	errorMessageText = "An internal bot error occurred."
+	errorMessageText    = "An internal bot error occurred."
+	conflictMessageText = "Your previous action was interrupted by another one, please repeat it."
 )
 
 var (
@@ -22,6 +25,10 @@ var (
 type Settings struct {
 	SupportGroups       bool
 	OnlyAppealsInGroups bool
+	AdminChatIDs        []int
+	DeepLinks           []*core.DeepLink
+	BotName             string
+	SessionIdleTTL      time.Duration
 }
 
 type UIPresenter struct {
@@ -29,19 +36,45 @@ type UIPresenter struct {
 	messenger      messenger.Messenger
 	sessionStorage core.Storage
 	pageRegistry   map[string]page.Page
-	//globalIntents []*core.Intent
-	settings *Settings
+	signer         *core.URLSigner
+	deepLinks      *core.DeepLinks
+	commands       map[string]*core.URL
+	globalIntents  []*core.Intent
+	chatLocks      *chatLocks
+	settings       *Settings
 }
 
 func New(messenger messenger.Messenger, storage core.Storage, pageRegistry map[string]page.Page,
-	settings *Settings) *UIPresenter {
+	signer *core.URLSigner, settings *Settings) *UIPresenter {
 
 	logger := logging.NewObjectLogger("ui_presenter", nil)
 	if settings == nil {
 		settings = &DefaultSettings
 	}
+	commands := make(map[string]*core.URL)
+	var globalIntents []*core.Intent
+	for _, pg := range pageRegistry {
+		for _, command := range pg.GetCommands() {
+			commands[command.Name] = command.Handler
+		}
+		globalIntents = append(globalIntents, pg.GetGlobalIntents()...)
+	}
 	return &UIPresenter{ObjectLogger: logger, messenger: messenger, sessionStorage: storage,
-		pageRegistry: pageRegistry, settings: settings}
+		pageRegistry: pageRegistry, signer: signer, deepLinks: core.NewDeepLinks(settings.DeepLinks),
+		commands: commands, globalIntents: globalIntents, chatLocks: newChatLocks(), settings: settings}
+}
+
+// Commands returns slash commands of pages available to everyone, e.g to publish them in the messenger commands menu.
+func (uip *UIPresenter) Commands() []*page.BotCommand {
+	var commands []*page.BotCommand
+	for _, pg := range uip.pageRegistry {
+		if len(pg.GetRequiredRoles()) != 0 {
+			continue
+		}
+		commands = append(commands, pg.GetCommands()...)
+	}
+	sort.Slice(commands, func(i, j int) bool { return commands[i].Name < commands[j].Name })
+	return commands
 }
 
 func (uip *UIPresenter) OnQueueMessage(ctx context.Context, msg *msgsqueue.Message) {
@@ -49,20 +82,71 @@ func (uip *UIPresenter) OnQueueMessage(ctx context.Context, msg *msgsqueue.Messa
 		return
 	}
 	req := core.NewRequestFromQueueMsg(ctx, msg)
+	if req.URL != nil {
+		req.URL = uip.verifyURL(req)
+	} else if command, args, isCommand := core.ParseCommand(req.MsgText, uip.settings.BotName); isCommand {
+		req.URL = uip.commandURL(ctx, command, args)
+	}
 	ok := uip.HandleRequest(req)
 	if !ok {
 		uip.sendError(ctx, msg)
 	}
 }
 
+func (uip *UIPresenter) verifyURL(req *core.Request) *core.URL {
+	u, err := uip.signer.Verify(req.ChatID, req.URL)
+	if err != nil {
+		uip.GetLogger(req.Ctx).WithField("url", req.URL.Encode()).Warnf("Reject incoming url: %s", err)
+		return core.NotFoundPageURL
+	}
+	return u
+}
+
+func (uip *UIPresenter) storedButtonHandler(req *core.Request) *core.URL {
+	handler, err := core.StoredButtonHandler(req.Session, req.URL)
+	if err != nil {
+		uip.GetLogger(req.Ctx).WithField("url", req.URL.Encode()).Warnf("Reject incoming url: %s", err)
+		return core.NotFoundPageURL
+	}
+	return handler
+}
+
+func (uip *UIPresenter) commandURL(ctx context.Context, command, args string) *core.URL {
+	if command == core.StartCommand {
+		return uip.deepLinks.Resolve(ctx, args)
+	}
+	commandURL, ok := uip.commands[command]
+	if !ok {
+		uip.GetLogger(ctx).WithField("command", command).Info("Unknown command, handle it as a plain text")
+		return nil
+	}
+	if args != "" {
+		return commandURL.WithParam(core.CommandArgsParam, args)
+	}
+	return commandURL
+}
+
 func (uip *UIPresenter) HandleRequest(req *core.Request) bool {
 	logger := uip.GetLogger(req.Ctx)
+	unlock := uip.chatLocks.lock(req.ChatID)
+	defer unlock()
 	session, err := uip.getOrCreateSession(req.Ctx, req.ChatID)
 	if err != nil {
 		logger.Errorf("Cannot init chat session: %s", err)
 		return false
 	}
+	now := time.Now().UTC()
+	if session.IsIdle(uip.settings.SessionIdleTTL, now) {
+		session.Expire(req.Ctx)
+		if req.URL == nil {
+			req.URL = core.DefaultPageURL
+		}
+	}
+	session.Touch(now)
 	req.SetSession(session)
+	if req.URL != nil {
+		req.URL = uip.storedButtonHandler(req)
+	}
 	ok := uip.dispatchRequest(req)
 	if !ok {
 		return false
@@ -80,6 +164,9 @@ func (uip *UIPresenter) dispatchRequest(req *core.Request) bool {
 		logger.Infof("Session contains input handler %s, set it to the request url", req.Session.InputHandler.Encode())
 		req.URL = req.Session.InputHandler
 		req.Session.ResetInputHandler(req.Ctx)
+	} else if handler := core.MatchIntent(uip.globalIntents, req.MsgText); handler != nil {
+		logger.Infof("Request url %s from global intent", handler.Encode())
+		req.URL = handler
 	} else {
 		lastPageURL := req.Session.LastPage
 		lastPage, err := uip.getPage(lastPageURL)
@@ -96,12 +183,20 @@ func (uip *UIPresenter) dispatchRequest(req *core.Request) bool {
 		}
 		logger.Infof("Request url %s from intent handling", req.URL.Encode())
 	}
+	var screen *core.URL
+	var screenPage page.Page
 	for req.URL != nil {
 		pg, err := uip.getPage(req.URL)
 		if err != nil {
 			logger.Errorf("Cannot get page during request iteration: %s", err)
 			return false
 		}
+		if !uip.hasAccess(req.ChatID, pg) {
+			logger.WithField("required_roles", pg.GetRequiredRoles()).Warnf("Chat has no access to %s",
+				req.URL.Encode())
+			req.URL = core.NotFoundPageURL
+			continue
+		}
 		logger.Infof("Enter %s", req.URL.Encode())
 		nextURL, err := pg.Enter(req)
 		if err != nil {
@@ -109,8 +204,12 @@ func (uip *UIPresenter) dispatchRequest(req *core.Request) bool {
 			return false
 		}
 		req.Session.SetLastPage(req.Ctx, req.URL)
+		screen, screenPage = req.URL, pg
 		req.URL = nextURL
 	}
+	if screen != nil && !screenPage.IsTransient(screen) {
+		req.Session.PushHistory(req.Ctx, screen)
+	}
 	logger.Info("Pages iteration is successfully over")
 	return true
 }
@@ -119,6 +218,12 @@ func (uip *UIPresenter) saveSession(req *core.Request) bool {
 	logger := uip.GetLogger(req.Ctx)
 	logger.Info("Saving session to the storage")
 	err := uip.sessionStorage.Save(req.Ctx, req.Session)
+	if core.IsConflict(err) {
+		// the request has already sent its messages, so it's not safe to replay it on the fresh session
+		logger.Warnf("Session saving conflict: %s", err)
+		uip.sendText(req.Ctx, req.ChatID, conflictMessageText)
+		return true
+	}
 	if err != nil {
 		logger.Errorf("Session saving failed: %s", err)
 		return false
@@ -141,16 +246,60 @@ func (uip *UIPresenter) getOrCreateSession(ctx context.Context, chatID int) (*co
 	if session.LastPage == nil {
 		session.LastPage = core.DefaultPageURL
 	}
+	uip.dropUnresolvableURLs(ctx, session)
 	return session, nil
 }
 
+func (uip *UIPresenter) isResolvable(u *core.URL) bool {
+	pg, err := uip.getPage(u)
+	return err == nil && pg.HasAction(u.Action)
+}
+
+// dropUnresolvableURLs resets session urls leading to pages or actions that don't exist anymore,
+// e.g renamed ones without a migration, so the user gets to the default page instead of an error.
+func (uip *UIPresenter) dropUnresolvableURLs(ctx context.Context, session *core.Session) {
+	logger := uip.GetLogger(ctx).WithField("chat_id", session.ChatID)
+	if !uip.isResolvable(session.LastPage) {
+		logger.Warnf("Reset unresolvable last page %s", session.LastPage.Encode())
+		session.LastPage = core.DefaultPageURL
+	}
+	if session.InputHandler != nil && !uip.isResolvable(session.InputHandler) {
+		logger.Warnf("Reset unresolvable input handler %s", session.InputHandler.Encode())
+		session.InputHandler = nil
+	}
+	intents := session.LocalIntents[:0]
+	for _, intent := range session.LocalIntents {
+		if uip.isResolvable(intent.Handler) {
+			intents = append(intents, intent)
+		}
+	}
+	session.LocalIntents = intents
+	history := session.History[:0]
+	for _, screen := range session.History {
+		if uip.isResolvable(screen) {
+			history = append(history, screen)
+		}
+	}
+	session.History = history
+	buttons := session.StoredButtons[:0]
+	for _, button := range session.StoredButtons {
+		if uip.isResolvable(button.Handler) {
+			buttons = append(buttons, button)
+		}
+	}
+	session.StoredButtons = buttons
+}
+
 func (uip *UIPresenter) sendError(ctx context.Context, msg *msgsqueue.Message) {
-	logger := uip.GetLogger(ctx)
-	logger.WithField("chat_id", msg.Chat.ID).Info("Sending error msg to the chat")
-	_, err := uip.messenger.SendText(ctx, msg.Chat.ID, errorMessageText)
+	uip.sendText(ctx, msg.Chat.ID, errorMessageText)
+}
+
+func (uip *UIPresenter) sendText(ctx context.Context, chatID int, text string) {
+	logger := uip.GetLogger(ctx).WithField("chat_id", chatID)
+	logger.WithField("text", text).Info("Sending service msg to the chat")
+	_, err := uip.messenger.SendText(ctx, chatID, text)
 	if err != nil {
-		logger.Errorf("Cannot send error msg: %s", err)
-		return
+		logger.Errorf("Cannot send service msg: %s", err)
 	}
 }
 
@@ -162,6 +311,31 @@ func (uip *UIPresenter) getPage(pageURL *core.URL) (page.Page, error) {
 	return pg, nil
 }
 
+func (uip *UIPresenter) chatRoles(chatID int) map[string]bool {
+	roles := make(map[string]bool)
+	for _, adminChatID := range uip.settings.AdminChatIDs {
+		if adminChatID == chatID {
+			roles[core.AdminRole] = true
+			break
+		}
+	}
+	return roles
+}
+
+func (uip *UIPresenter) hasAccess(chatID int, pg page.Page) bool {
+	requiredRoles := pg.GetRequiredRoles()
+	if len(requiredRoles) == 0 {
+		return true
+	}
+	roles := uip.chatRoles(chatID)
+	for _, role := range requiredRoles {
+		if !roles[role] {
+			return false
+		}
+	}
+	return true
+}
+
 func (uip *UIPresenter) needSkip(ctx context.Context, msg *msgsqueue.Message) bool {
 	if msg.Chat.IsPrivate {
 		return false
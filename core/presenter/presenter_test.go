@@ -0,0 +1,102 @@
+package presenter_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"reminder/core"
+	"reminder/core/page"
+	"reminder/core/presenter"
+	"reminder/pages"
+
+	"github.com/gazoon/bot_libs/messenger"
+)
+
+const (
+	adminChatID = 100500
+	userChatID  = 100501
+)
+
+type sentMessage struct {
+	chatID int
+	text   string
+}
+
+// fakeMessenger records sent messages, methods the pages don't use panic on the nil embedded interface.
+type fakeMessenger struct {
+	messenger.Messenger
+	sent []*sentMessage
+}
+
+func (fm *fakeMessenger) SendText(ctx context.Context, chatID int, text string) (int, error) {
+	fm.sent = append(fm.sent, &sentMessage{chatID: chatID, text: text})
+	return len(fm.sent), nil
+}
+
+func (fm *fakeMessenger) SendTextWithButtons(ctx context.Context, chatID int, text string,
+	buttons ...*messenger.Button) (int, error) {
+
+	return fm.SendText(ctx, chatID, text)
+}
+
+func newPresenter(t *testing.T) (*presenter.UIPresenter, *fakeMessenger, core.Storage) {
+	signer, err := core.NewURLSigner("test secret")
+	if err != nil {
+		t.Fatalf("signer: %s", err)
+	}
+	fakeMsgr := &fakeMessenger{}
+	builder := page.NewPagesBuilder(fakeMsgr, signer, "../../views")
+	registry, err := builder.InstantiatePages(&pages.Home{}, &pages.NotFound{}, &pages.SessionInfo{})
+	if err != nil {
+		t.Fatalf("pages registry: %s", err)
+	}
+	settings := presenter.DefaultSettings
+	settings.AdminChatIDs = []int{adminChatID}
+	storage := core.NewInMemoryStorage()
+	return presenter.New(fakeMsgr, storage, registry, signer, &settings), fakeMsgr, storage
+}
+
+func TestAdminPageAccess(t *testing.T) {
+	cases := []struct {
+		name         string
+		chatID       int
+		expectedPage string
+		expectedText string
+	}{
+		{"admin chat", adminChatID, "session_info", "Chat 100500, session"},
+		{"not admin chat", userChatID, core.NotFoundPageURL.Page, "I don't understand you"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			uiPresenter, fakeMsgr, storage := newPresenter(t)
+			ctx := context.Background()
+			req := &core.Request{Ctx: ctx, ChatID: c.chatID, URL: core.NewURL("session_info", "", nil)}
+			if !uiPresenter.HandleRequest(req) {
+				t.Fatal("request handling failed")
+			}
+			if len(fakeMsgr.sent) == 0 {
+				t.Fatal("expected a message, got nothing")
+			}
+			if text := fakeMsgr.sent[0].text; !strings.Contains(text, c.expectedText) {
+				t.Errorf("expected a message with %q, got %q", c.expectedText, text)
+			}
+			session, err := storage.Get(ctx, c.chatID)
+			if err != nil {
+				t.Fatalf("session: %s", err)
+			}
+			if session.LastPage.Page != c.expectedPage {
+				t.Errorf("expected last page %s, got %s", c.expectedPage, session.LastPage.Encode())
+			}
+		})
+	}
+}
+
+func TestCommandsSkipAdminPages(t *testing.T) {
+	uiPresenter, _, _ := newPresenter(t)
+	for _, command := range uiPresenter.Commands() {
+		if command.Name == "session" {
+			t.Errorf("admin command %s is published", command.Name)
+		}
+	}
+}
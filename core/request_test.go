@@ -0,0 +1,37 @@
+package core_test
+
+import (
+	"context"
+	"testing"
+
+	"reminder/core"
+
+	msgsqueue "github.com/gazoon/bot_libs/queue/messages"
+)
+
+func TestNewRequestFromQueueMsg(t *testing.T) {
+	chat := &msgsqueue.Chat{ID: 100500, IsPrivate: true}
+	t.Run("text", func(t *testing.T) {
+		req := core.NewRequestFromQueueMsg(context.Background(), &msgsqueue.Message{Chat: chat, Text: "hello"})
+		msg, ok := req.Msg.(*core.TextMessage)
+		if !ok || msg.Text != "hello" {
+			t.Fatalf("expected text message, got %#v", req.Msg)
+		}
+	})
+	t.Run("location", func(t *testing.T) {
+		queueMsg := &msgsqueue.Message{Chat: chat, Location: &msgsqueue.Location{Latitude: 52.52, Longitude: 13.4}}
+		req := core.NewRequestFromQueueMsg(context.Background(), queueMsg)
+		msg, ok := req.Msg.(*core.LocationMessage)
+		if !ok || msg.Latitude != 52.52 || msg.Longitude != 13.4 {
+			t.Fatalf("expected location message, got %#v", req.Msg)
+		}
+	})
+	t.Run("document", func(t *testing.T) {
+		document := &msgsqueue.Document{FileID: "file-id", FileName: "calendar.ics", FileSize: 1024}
+		req := core.NewRequestFromQueueMsg(context.Background(), &msgsqueue.Message{Chat: chat, Document: document})
+		msg, ok := req.Msg.(*core.DocumentMessage)
+		if !ok || msg.FileID != "file-id" || msg.FileName != "calendar.ics" || msg.FileSize != 1024 {
+			t.Fatalf("expected document message, got %#v", req.Msg)
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package core
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"strconv"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	signatureParam = "sig"
+	signatureSize  = 12
+	// MaxButtonPayloadSize is the telegram limit of the inline button callback data.
+	MaxButtonPayloadSize = 64
+	// StoredButtonPage is the pseudo page of payloads referring to a button handler stored in the session.
+	StoredButtonPage     = "button"
+	storedButtonKeyParam = "key"
+)
+
+// compromisedSecrets contains sha256 hashes of secrets that were published, urls signed with them can be forged.
+var compromisedSecrets = map[string]bool{
+	// the secret committed to conf.json before it was moved to the environment
+	"e89677b23ce21a7bc088aa401665efaca2857544d18ca0196c0f37b234093473": true,
+}
+
+// URLSigner signs urls that are sent to the user as button payloads, so that a user
+// can't forge a navigation url by typing it as a plain text message.
+// Signature is bound to the chat, a url signed for one chat is not valid for another one.
+type URLSigner struct {
+	key []byte
+}
+
+func NewURLSigner(secret string) (*URLSigner, error) {
+	if secret == "" {
+		return nil, errors.New("empty url signing secret")
+	}
+	hash := sha256.Sum256([]byte(secret))
+	if compromisedSecrets[hex.EncodeToString(hash[:])] {
+		return nil, errors.New("url signing secret is compromised, generate a new one")
+	}
+	return &URLSigner{key: []byte(secret)}, nil
+}
+
+func (s *URLSigner) Sign(chatID int, u *URL) *URL {
+	signed := u.withoutSignature()
+	signed.Params[signatureParam] = s.signature(chatID, signed)
+	return signed
+}
+
+// ButtonPayload returns the signed handler to send as the button payload. A handler that doesn't fit
+// in MaxButtonPayloadSize, e.g with a reminder id and a few params, is stored in the session
+// and the payload is a signed reference to it, see StoredButtonHandler.
+func (s *URLSigner) ButtonPayload(session *Session, handler *URL) string {
+	signed := s.Sign(session.ChatID, handler)
+	payload := signed.Encode()
+	if len(payload) <= MaxButtonPayloadSize {
+		return payload
+	}
+	key := signed.Params[signatureParam]
+	session.StoreButton(key, handler)
+	reference := NewURL(StoredButtonPage, "", map[string]string{storedButtonKeyParam: key})
+	return s.Sign(session.ChatID, reference).Encode()
+}
+
+// StoredButtonHandler returns the handler a verified button payload refers to, the url itself if it's not a reference.
+func StoredButtonHandler(session *Session, u *URL) (*URL, error) {
+	if u.Page != StoredButtonPage {
+		return u, nil
+	}
+	handler := session.StoredButton(u.Params[storedButtonKeyParam])
+	if handler == nil {
+		return nil, errors.New("button is not stored in the session anymore")
+	}
+	return handler, nil
+}
+
+func (s *URLSigner) Verify(chatID int, u *URL) (*URL, error) {
+	signature, ok := u.Params[signatureParam]
+	if !ok {
+		return nil, errors.New("url is not signed")
+	}
+	unsigned := u.withoutSignature()
+	expected := s.signature(chatID, unsigned)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return nil, errors.New("url signature mismatch")
+	}
+	return unsigned, nil
+}
+
+func (s *URLSigner) signature(chatID int, u *URL) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(strconv.Itoa(chatID)))
+	mac.Write([]byte(u.Encode()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:signatureSize])
+}
+
+func (u *URL) withoutSignature() *URL {
+	params := make(map[string]string, len(u.Params))
+	for k, v := range u.Params {
+		if k == signatureParam {
+			continue
+		}
+		params[k] = v
+	}
+	return NewURL(u.Page, u.Action, params)
+}
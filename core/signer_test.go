@@ -0,0 +1,75 @@
+package core_test
+
+import (
+	"testing"
+
+	"reminder/core"
+)
+
+const (
+	chatID      = 100500
+	otherChatID = 100501
+	reminderID  = "6ba7b810-9dad-11d1-80b4-00c04fd430c8"
+)
+
+func TestButtonPayload(t *testing.T) {
+	signer, err := core.NewURLSigner("test secret")
+	if err != nil {
+		t.Fatalf("signer: %s", err)
+	}
+	// the longest handlers of the views, they carry a reminder id and an action
+	handlers := []*core.URL{
+		core.HomePageURL,
+		core.NewURL("show_reminder", "snooze", map[string]string{"reminder_id": reminderID, "delay": "tomorrow"}),
+		core.NewURL("reminder_edit", "enter_tags", map[string]string{"reminder_id": reminderID}),
+		core.NewURL("reminder_list", "", map[string]string{"tag": "a-rather-long-tag-name", "page": "100"}),
+	}
+	for _, handler := range handlers {
+		t.Run(handler.Encode(), func(t *testing.T) {
+			session := core.NewSession(chatID)
+			payload := signer.ButtonPayload(session, handler)
+			if len(payload) > core.MaxButtonPayloadSize {
+				t.Fatalf("payload %s is %d bytes long, the limit is %d", payload, len(payload),
+					core.MaxButtonPayloadSize)
+			}
+			u, err := core.NewURLFromStr(payload)
+			if err != nil {
+				t.Fatalf("payload parsing: %s", err)
+			}
+			if _, err := signer.Verify(otherChatID, u); err == nil {
+				t.Fatal("payload is verified for another chat")
+			}
+			u, err = signer.Verify(chatID, u)
+			if err != nil {
+				t.Fatalf("payload verification: %s", err)
+			}
+			resolved, err := core.StoredButtonHandler(session, u)
+			if err != nil {
+				t.Fatalf("stored button: %s", err)
+			}
+			if resolved.Encode() != handler.Encode() {
+				t.Errorf("expected %s, got %s", handler.Encode(), resolved.Encode())
+			}
+		})
+	}
+}
+
+func TestButtonPayloadDroppedFromSession(t *testing.T) {
+	signer, err := core.NewURLSigner("test secret")
+	if err != nil {
+		t.Fatalf("signer: %s", err)
+	}
+	session := core.NewSession(chatID)
+	handler := core.NewURL("reminder_edit", "enter_tags", map[string]string{"reminder_id": reminderID})
+	u, err := core.NewURLFromStr(signer.ButtonPayload(session, handler))
+	if err != nil {
+		t.Fatalf("payload parsing: %s", err)
+	}
+	u, err = signer.Verify(chatID, u)
+	if err != nil {
+		t.Fatalf("payload verification: %s", err)
+	}
+	if _, err := core.StoredButtonHandler(core.NewSession(chatID), u); err == nil {
+		t.Error("expected an error for a button missing in the session")
+	}
+}
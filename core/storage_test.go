@@ -0,0 +1,30 @@
+package core_test
+
+import (
+	"testing"
+
+	"reminder/core"
+	"reminder/storages/storagetest"
+)
+
+func TestInMemoryStorage(t *testing.T) {
+	storagetest.RunSessionStorage(t, func(t *testing.T) core.Storage { return core.NewInMemoryStorage() })
+}
+
+func TestMongoStorage(t *testing.T) {
+	mongo := storagetest.Mongo(t)
+	storagetest.RunSessionStorage(t, func(t *testing.T) core.Storage {
+		collection := mongo.NewCollection(t)
+		err := core.EnsureMongoIndexes(mongo.Database, collection, mongo.User, mongo.Password, mongo.Host, mongo.Port,
+			mongo.Timeout)
+		if err != nil {
+			t.Fatalf("mongo indexes: %s", err)
+		}
+		storage, err := core.NewMongoStorage(mongo.Database, collection, mongo.User, mongo.Password, mongo.Host,
+			mongo.Port, mongo.Timeout, mongo.PoolSize, mongo.RetriesNum, mongo.RetriesInterval)
+		if err != nil {
+			t.Fatalf("mongo storage: %s", err)
+		}
+		return storage
+	})
+}
@@ -0,0 +1,297 @@
+package dateparse
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	defaultHour = 9
+	noonHour    = 12
+)
+
+var (
+	absoluteLayouts = []string{
+		"2006-01-02 15:04:05", "2006.01.02 15:04:05", "2006-01-02 15:04", "2006.01.02 15:04",
+	}
+	absoluteDateLayouts = []string{"2006-01-02", "2006.01.02"}
+
+	clockRegexp        = regexp.MustCompile(`^(\d{1,2})(?:[:.](\d{2}))?(am|pm)?$`)
+	compactDurationReg = regexp.MustCompile(`^(\d+)([a-z]+)$`)
+
+	weekdays = map[string]time.Weekday{
+		"monday": time.Monday, "mon": time.Monday, "tuesday": time.Tuesday, "tue": time.Tuesday,
+		"wednesday": time.Wednesday, "wed": time.Wednesday, "thursday": time.Thursday, "thu": time.Thursday,
+		"friday": time.Friday, "fri": time.Friday, "saturday": time.Saturday, "sat": time.Saturday,
+		"sunday": time.Sunday, "sun": time.Sunday,
+	}
+	months = map[string]time.Month{
+		"january": time.January, "jan": time.January, "february": time.February, "feb": time.February,
+		"march": time.March, "mar": time.March, "april": time.April, "apr": time.April, "may": time.May,
+		"june": time.June, "jun": time.June, "july": time.July, "jul": time.July, "august": time.August,
+		"aug": time.August, "september": time.September, "sep": time.September, "sept": time.September,
+		"october": time.October, "oct": time.October, "november": time.November, "nov": time.November,
+		"december": time.December, "dec": time.December,
+	}
+	units = map[string]time.Duration{
+		"m": time.Minute, "min": time.Minute, "mins": time.Minute, "minute": time.Minute, "minutes": time.Minute,
+		"h": time.Hour, "hr": time.Hour, "hrs": time.Hour, "hour": time.Hour, "hours": time.Hour,
+	}
+	// calendar units are added with AddDate, so they keep the time of day over DST changes
+	calendarUnits = map[string]int{
+		"d": 1, "day": 1, "days": 1, "w": 7, "week": 7, "weeks": 7,
+	}
+	fillerWords = map[string]bool{"at": true, "on": true, "the": true, "of": true}
+)
+
+type clock struct {
+	hour, minute int
+}
+
+type date struct {
+	year    int
+	month   time.Month
+	day     int
+	hasYear bool
+	// explicit is set for dates with a month, without a year they are moved to the next year when passed
+	explicit bool
+}
+
+type parser struct {
+	now    time.Time
+	tokens []string
+	pos    int
+	date   *date
+	clock  *clock
+}
+
+// Parse interprets absolute dates like "2030-01-02 15:04" and phrases like "tomorrow 9am", "in 2 hours",
+// "next monday 18:00", "17 Oct" or "at 14:30" in the given location. A time without a date means today,
+// or tomorrow if it has already passed, a date without a time means 9am. The result is in UTC.
+func Parse(text string, now time.Time, loc *time.Location) (time.Time, error) {
+	text = strings.TrimSpace(text)
+	if text == "" {
+		return time.Time{}, errors.New("empty date")
+	}
+	for _, layout := range absoluteLayouts {
+		t, err := time.ParseInLocation(layout, text, loc)
+		if err == nil {
+			return t.UTC(), nil
+		}
+	}
+	for _, layout := range absoluteDateLayouts {
+		t, err := time.ParseInLocation(layout, text, loc)
+		if err == nil {
+			return time.Date(t.Year(), t.Month(), t.Day(), defaultHour, 0, 0, 0, loc).UTC(), nil
+		}
+	}
+	tokens := strings.Fields(strings.ToLower(strings.NewReplacer(",", " ").Replace(text)))
+	if len(tokens) == 0 {
+		return time.Time{}, errors.Errorf("cannot understand %q", text)
+	}
+	if tokens[0] == "in" {
+		return parseRelative(tokens[1:], now.In(loc))
+	}
+	p := &parser{now: now.In(loc), tokens: tokens}
+	return p.parse()
+}
+
+// parseRelative parses durations like "2 hours", "an hour", "1 hour 30 minutes" or "1h30m".
+func parseRelative(tokens []string, now time.Time) (time.Time, error) {
+	if len(tokens) == 0 {
+		return time.Time{}, errors.New("duration is missing after 'in'")
+	}
+	result := now
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		if token == "and" {
+			continue
+		}
+		var amount int
+		var unit string
+		if token == "a" || token == "an" {
+			amount = 1
+		} else if n, err := strconv.Atoi(token); err == nil {
+			amount = n
+		} else if d, err := time.ParseDuration(token); err == nil && d > 0 {
+			result = result.Add(d)
+			continue
+		} else if match := compactDurationReg.FindStringSubmatch(token); match != nil {
+			amount, _ = strconv.Atoi(match[1])
+			unit = match[2]
+		} else {
+			return time.Time{}, errors.Errorf("cannot understand %q", token)
+		}
+		if unit == "" {
+			if i+1 == len(tokens) {
+				return time.Time{}, errors.Errorf("unit is missing after %q", token)
+			}
+			i++
+			unit = tokens[i]
+		}
+		if d, ok := units[unit]; ok {
+			result = result.Add(time.Duration(amount) * d)
+		} else if days, ok := calendarUnits[unit]; ok {
+			result = result.AddDate(0, 0, amount*days)
+		} else {
+			return time.Time{}, errors.Errorf("unknown time unit %q", unit)
+		}
+	}
+	return result.UTC(), nil
+}
+
+func (p *parser) parse() (time.Time, error) {
+	for p.pos < len(p.tokens) {
+		err := p.parseToken()
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	if p.date == nil && p.clock == nil {
+		return time.Time{}, errors.New("neither date nor time is specified")
+	}
+	c := p.clock
+	if c == nil {
+		c = &clock{hour: defaultHour}
+	}
+	d := p.date
+	if d == nil {
+		d = &date{year: p.now.Year(), month: p.now.Month(), day: p.now.Day()}
+	}
+	result := time.Date(d.year, d.month, d.day, c.hour, c.minute, 0, 0, p.now.Location())
+	if p.date == nil && !result.After(p.now) {
+		result = result.AddDate(0, 0, 1)
+	}
+	if p.date != nil && p.date.explicit && !p.date.hasYear && result.Before(p.now) {
+		result = result.AddDate(1, 0, 0)
+	}
+	return result.UTC(), nil
+}
+
+func (p *parser) next() string {
+	token := p.tokens[p.pos]
+	p.pos++
+	return token
+}
+
+func (p *parser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *parser) parseToken() error {
+	token := p.next()
+	switch {
+	case fillerWords[token]:
+		return nil
+	case token == "today":
+		return p.setDate(p.now)
+	case token == "tomorrow":
+		return p.setDate(p.now.AddDate(0, 0, 1))
+	case token == "next":
+		weekday, ok := weekdays[p.peek()]
+		if !ok {
+			return errors.New("'next' must be followed by a day of the week")
+		}
+		p.pos++
+		return p.setDate(p.nextWeekday(weekday))
+	case token == "noon":
+		return p.setClock(noonHour, 0)
+	case token == "midnight":
+		return p.setClock(0, 0)
+	}
+	if weekday, ok := weekdays[token]; ok {
+		return p.setDate(p.nextWeekday(weekday))
+	}
+	if month, ok := months[token]; ok {
+		day, err := strconv.Atoi(strings.TrimRight(p.peek(), "stndrh"))
+		if err != nil {
+			return errors.Errorf("day is missing after %q", token)
+		}
+		p.pos++
+		return p.setDayMonth(day, month)
+	}
+	if match := clockRegexp.FindStringSubmatch(token); match != nil {
+		if month, ok := months[p.peek()]; ok && match[2] == "" && match[3] == "" {
+			p.pos++
+			day, _ := strconv.Atoi(match[1])
+			return p.setDayMonth(day, month)
+		}
+		meridiem := match[3]
+		if meridiem == "" && (p.peek() == "am" || p.peek() == "pm") {
+			meridiem = p.next()
+		}
+		hour, _ := strconv.Atoi(match[1])
+		minute, _ := strconv.Atoi(match[2])
+		return p.setMeridiemClock(hour, minute, meridiem)
+	}
+	if day, err := strconv.Atoi(strings.TrimRight(token, "stndrh")); err == nil {
+		if month, ok := months[p.peek()]; ok {
+			p.pos++
+			return p.setDayMonth(day, month)
+		}
+	}
+	return errors.Errorf("cannot understand %q", token)
+}
+
+func (p *parser) setMeridiemClock(hour, minute int, meridiem string) error {
+	switch meridiem {
+	case "am", "pm":
+		if hour < 1 || hour > 12 {
+			return errors.Errorf("hour must be between 1 and 12 with %s", meridiem)
+		}
+		hour %= 12
+		if meridiem == "pm" {
+			hour += 12
+		}
+	}
+	return p.setClock(hour, minute)
+}
+
+func (p *parser) setClock(hour, minute int) error {
+	if p.clock != nil {
+		return errors.New("time is specified twice")
+	}
+	if hour > 23 || minute > 59 {
+		return errors.Errorf("invalid time %d:%02d", hour, minute)
+	}
+	p.clock = &clock{hour: hour, minute: minute}
+	return nil
+}
+
+func (p *parser) setDayMonth(day int, month time.Month) error {
+	d := &date{year: p.now.Year(), month: month, day: day, explicit: true}
+	if year, err := strconv.Atoi(p.peek()); err == nil && year >= 1000 {
+		p.pos++
+		d.year = year
+		d.hasYear = true
+	}
+	if day < 1 || day > time.Date(d.year, month+1, 0, 0, 0, 0, 0, time.UTC).Day() {
+		return errors.Errorf("%s doesn't have day %d", month, day)
+	}
+	if p.date != nil {
+		return errors.New("date is specified twice")
+	}
+	p.date = d
+	return nil
+}
+
+func (p *parser) setDate(t time.Time) error {
+	if p.date != nil {
+		return errors.New("date is specified twice")
+	}
+	p.date = &date{year: t.Year(), month: t.Month(), day: t.Day()}
+	return nil
+}
+
+// nextWeekday returns the closest such day after today.
+func (p *parser) nextWeekday(weekday time.Weekday) time.Time {
+	days := (int(weekday)-int(p.now.Weekday())+6)%7 + 1
+	return p.now.AddDate(0, 0, days)
+}
@@ -0,0 +1,70 @@
+package dateparse_test
+
+import (
+	"testing"
+	"time"
+
+	"reminder/dateparse"
+)
+
+func TestParse(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("load location: %s", err)
+	}
+	// friday, daylight saving time starts on sunday 2030-03-31
+	now := time.Date(2030, 3, 29, 12, 0, 0, 0, loc)
+	local := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, loc)
+	}
+	cases := []struct {
+		text     string
+		expected time.Time
+	}{
+		{"2030-01-02 15:04:05", time.Date(2030, 1, 2, 15, 4, 5, 0, loc)},
+		{"2030.01.02 15:04", local(2030, 1, 2, 15, 4)},
+		{"2030-03-31", local(2030, 3, 31, 9, 0)},
+		{"tomorrow 9am", local(2030, 3, 30, 9, 0)},
+		{"Tomorrow, 6 pm", local(2030, 3, 30, 18, 0)},
+		{"today at noon", local(2030, 3, 29, 12, 0)},
+		{"in 2 hours", local(2030, 3, 29, 14, 0)},
+		{"in an hour and 15 minutes", local(2030, 3, 29, 13, 15)},
+		{"in 1h30m", local(2030, 3, 29, 13, 30)},
+		{"in 2 days", local(2030, 3, 31, 12, 0)},
+		{"next monday 18:00", local(2030, 4, 1, 18, 0)},
+		{"friday", local(2030, 4, 5, 9, 0)},
+		{"17 Oct", local(2030, 10, 17, 9, 0)},
+		{"Oct 17th 2031 7.45pm", local(2031, 10, 17, 19, 45)},
+		{"5 jan", local(2031, 1, 5, 9, 0)},
+		{"at 14:30", local(2030, 3, 29, 14, 30)},
+		{"at 11:00", local(2030, 3, 30, 11, 0)},
+		{"midnight", local(2030, 3, 30, 0, 0)},
+	}
+	for _, c := range cases {
+		t.Run(c.text, func(t *testing.T) {
+			result, err := dateparse.Parse(c.text, now, loc)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !result.Equal(c.expected) {
+				t.Errorf("expected %s, got %s", c.expected, result.In(loc))
+			}
+			if result.Location() != time.UTC {
+				t.Errorf("expected utc result, got %s", result.Location())
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	now := time.Date(2030, 3, 29, 12, 0, 0, 0, time.UTC)
+	for _, text := range []string{"", "   ", ",", " , ,", "in", "in 2", "in 2 fortnights", "next", "next week",
+		"13pm", "25:00", "31 feb", "tomorrow today", "9am 10am", "whenever"} {
+		t.Run(text, func(t *testing.T) {
+			result, err := dateparse.Parse(text, now, time.UTC)
+			if err == nil {
+				t.Errorf("expected an error, got %s", result)
+			}
+		})
+	}
+}
@@ -1,18 +1,23 @@
 package env
 
 import (
+	"context"
 	"reminder/config"
 	"reminder/core"
 	"reminder/core/page"
 	"reminder/core/presenter"
+	"reminder/geotz"
 
 	"github.com/gazoon/bot_libs/logging"
 	"github.com/gazoon/bot_libs/messenger"
 	"github.com/gazoon/bot_libs/queue/messages"
 	"github.com/pkg/errors"
 	"reminder/pages"
+	"reminder/sessions_cleaner"
 	"reminder/storages/chats"
 	"reminder/storages/reminders"
+	"reminder/telegram"
+	"time"
 )
 
 const (
@@ -36,6 +41,21 @@ func CreateTelegramMessenger() (messenger.Messenger, error) {
 	return telegramMessenger, errors.Wrap(err, "telegram messenger")
 }
 
+func createTelegramClient() *telegram.Client {
+	conf := config.GetInstance().Telegram
+	return telegram.NewClient(conf.APIToken, conf.HttpTimeout)
+}
+
+func PublishBotCommands(ctx context.Context, commands []*page.BotCommand) error {
+	client := createTelegramClient()
+	botCommands := make([]*telegram.BotCommand, len(commands))
+	for i, command := range commands {
+		botCommands[i] = &telegram.BotCommand{Command: command.Name, Description: command.Description}
+	}
+	err := client.SetMyCommands(ctx, botCommands)
+	return errors.Wrap(err, "telegram set commands")
+}
+
 func CreateMongoMsgs() (*msgsqueue.MongoQueue, error) {
 	conf := config.GetInstance().MongoMessages
 	incomingMongoQueue, err := msgsqueue.NewMongoQueue(conf.Database, conf.Collection, conf.User, conf.Password, conf.Host,
@@ -45,38 +65,125 @@ func CreateMongoMsgs() (*msgsqueue.MongoQueue, error) {
 
 func CreateMongoRemindersStorage() (*reminders.MongoStorage, error) {
 	conf := config.GetInstance().MongoReminders
+	deliveryConf := config.GetInstance().Delivery
+	delivery := &reminders.DeliverySettings{
+		Lease:         time.Duration(deliveryConf.Lease) * time.Second,
+		RetryDelay:    time.Duration(deliveryConf.RetryDelay) * time.Second,
+		MaxRetryDelay: time.Duration(deliveryConf.MaxRetryDelay) * time.Second,
+		MaxAttempts:   deliveryConf.MaxAttempts,
+	}
+	err := delivery.Validate()
+	if err != nil {
+		return nil, errors.Wrap(err, "delivery settings")
+	}
 	storage, err := reminders.NewMongoStorage(conf.Database, conf.Collection, conf.User, conf.Password, conf.Host,
-		conf.Port, conf.Timeout, conf.PoolSize, conf.RetriesNum, conf.RetriesInterval, conf.FetchDelay)
+		conf.Port, conf.Timeout, conf.PoolSize, conf.RetriesNum, conf.RetriesInterval, conf.FetchDelay, delivery)
 	return storage, errors.Wrap(err, "mongo reminders storage")
 }
 
-func CreateMongoChatsStorage() (chats.Storage, error) {
+func EnsureRemindersIndexes() error {
+	conf := config.GetInstance().MongoReminders
+	err := reminders.EnsureIndexes(conf.Database, conf.Collection, conf.User, conf.Password, conf.Host, conf.Port,
+		conf.Timeout)
+	return errors.Wrap(err, "mongo reminders indexes")
+}
+
+func CreateMongoChatsStorage() (*chats.MongoStorage, error) {
 	conf := config.GetInstance().MongoChats
 	storage, err := chats.NewMongoStorage(conf.Database, conf.Collection, conf.User, conf.Password, conf.Host,
 		conf.Port, conf.Timeout, conf.PoolSize, conf.RetriesNum, conf.RetriesInterval)
 	return storage, errors.Wrap(err, "mongo chats storage")
 }
 
-func CreateUIPresenter(messenger messenger.Messenger, remindersStorage reminders.Storage, chatsStorage chats.Storage) (
-	*presenter.UIPresenter, error) {
+func CreateMongoSessionsStorage() (*core.MongoStorage, error) {
+	conf := config.GetInstance().MongoSessions
+	storage, err := core.NewMongoStorage(conf.Database, conf.Collection, conf.User, conf.Password, conf.Host,
+		conf.Port, conf.Timeout, conf.PoolSize, conf.RetriesNum, conf.RetriesInterval)
+	return storage, errors.Wrap(err, "mongo sessions storage")
+}
+
+func EnsureSessionsIndexes() error {
+	conf := config.GetInstance().MongoSessions
+	err := core.EnsureMongoIndexes(conf.Database, conf.Collection, conf.User, conf.Password, conf.Host, conf.Port,
+		conf.Timeout)
+	return errors.Wrap(err, "mongo sessions indexes")
+}
+
+func CreateSessionsCleaner(storage core.Storage) (*sesscleaner.Cleaner, error) {
+	conf := config.GetInstance().Sessions
+	if conf.StorageTTL < conf.IdleTTL {
+		return nil, errors.Errorf("sessions storage ttl %ds is shorter than idle ttl %ds", conf.StorageTTL,
+			conf.IdleTTL)
+	}
+	cleaner, err := sesscleaner.NewCleaner(storage, time.Duration(conf.StorageTTL)*time.Second,
+		time.Duration(conf.CleanupInterval)*time.Second)
+	return cleaner, errors.Wrap(err, "sessions cleaner")
+}
 
-	builder := page.NewPagesBuilder(messenger, pageViewsFolder)
+func createGeoFinder() (*geotz.Finder, error) {
+	conf := config.GetInstance().Timezones
+	if conf == nil || conf.BoundariesFile == "" {
+		gLogger.Warn("Timezones boundaries file isn't set, shared locations get the zone of the nearest city")
+		return geotz.NewFinder(), nil
+	}
+	finder, err := geotz.LoadBoundaries(conf.BoundariesFile)
+	return finder, errors.Wrap(err, "timezones boundaries")
+}
+
+func CreateUIPresenter(messenger messenger.Messenger, sessionStorage core.Storage, remindersStorage reminders.Storage,
+	chatsStorage chats.Storage) (*presenter.UIPresenter, error) {
+
+	signer, err := core.NewURLSigner(config.GetInstance().Security.URLSecret)
+	if err != nil {
+		return nil, errors.Wrap(err, "url signer")
+	}
+	geoFinder, err := createGeoFinder()
+	if err != nil {
+		return nil, err
+	}
+	telegramClient := createTelegramClient()
+	builder := page.NewPagesBuilder(messenger, signer, pageViewsFolder)
+	help := &pages.Help{}
 	pagesRegistry, err := builder.InstantiatePages(
-		&pages.ChangeTimezone{Chats: chatsStorage},
+		&pages.Back{},
+		&pages.ChangeTimezone{Chats: chatsStorage, Geo: geoFinder},
+		help,
 		&pages.Home{},
 		&pages.NotFound{},
-		&pages.ReminderList{Reminders: remindersStorage},
-		&pages.ShowReminder{Reminders: remindersStorage, Chats: chatsStorage},
+		&pages.ReminderList{Reminders: remindersStorage, Chats: chatsStorage},
+		&pages.Search{Reminders: remindersStorage},
+		&pages.SessionInfo{},
+		&pages.Calendar{Reminders: remindersStorage, Chats: chatsStorage, Files: telegramClient},
+		&pages.ShowReminder{Reminders: remindersStorage, Chats: chatsStorage, Editor: telegramClient},
+		&pages.ReminderEdit{Reminders: remindersStorage, Chats: chatsStorage},
 		&pages.ReminderCreation{Reminders: remindersStorage, Chats: chatsStorage, Messenger: messenger},
 	)
 	if err != nil {
 		return nil, errors.Wrap(err, "pages registry")
 	}
-	conf := config.GetInstance().MongoSessions
-	sessionStorage, err := core.NewMongoStorage(conf.Database, conf.Collection, conf.User, conf.Password, conf.Host,
-		conf.Port, conf.Timeout, conf.PoolSize, conf.RetriesNum, conf.RetriesInterval)
+	deepLinks, err := createDeepLinks()
 	if err != nil {
-		return nil, errors.Wrap(err, "mongo storage")
+		return nil, errors.Wrap(err, "deep links")
+	}
+	settings := presenter.DefaultSettings
+	settings.AdminChatIDs = config.GetInstance().AdminChatIDs
+	settings.DeepLinks = deepLinks
+	settings.BotName = config.GetInstance().Telegram.BotName
+	settings.SessionIdleTTL = time.Duration(config.GetInstance().Sessions.IdleTTL) * time.Second
+	uiPresenter := presenter.New(messenger, sessionStorage, pagesRegistry, signer, &settings)
+	help.Commands = uiPresenter.Commands
+	return uiPresenter, nil
+}
+
+func createDeepLinks() ([]*core.DeepLink, error) {
+	conf := config.GetInstance().DeepLinks
+	links := make([]*core.DeepLink, len(conf))
+	for i, linkConf := range conf {
+		var err error
+		links[i], err = core.NewDeepLink(linkConf.Name, linkConf.URL, linkConf.ArgParam)
+		if err != nil {
+			return nil, err
+		}
 	}
-	return presenter.New(messenger, sessionStorage, pagesRegistry, nil), nil
+	return links, nil
 }
@@ -0,0 +1,175 @@
+package geotz
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"reminder/timezones"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	earthRadiusKm = 6371
+	// points farther from any reference city, e.g in the ocean, get a nautical offset by the longitude
+	maxReferenceDistanceKm = 1000
+)
+
+type referencePoint struct {
+	zone      string
+	latitude  float64
+	longitude float64
+}
+
+// ring is a closed polygon ring of [longitude, latitude] points, as in GeoJSON.
+type ring [][2]float64
+
+type polygon struct {
+	outer ring
+	holes []ring
+}
+
+type zoneArea struct {
+	zone           string
+	polygons       []*polygon
+	minLon, minLat float64
+	maxLon, maxLat float64
+}
+
+// Finder resolves coordinates to IANA zones. Zone boundaries are optional, without them or for points
+// outside of all boundaries the zone of the nearest reference city is used.
+type Finder struct {
+	areas []*zoneArea
+}
+
+func NewFinder() *Finder {
+	return &Finder{}
+}
+
+// LoadBoundaries reads a GeoJSON feature collection with a "tzid" property per feature,
+// e.g the timezone-boundary-builder release.
+func LoadBoundaries(path string) (*Finder, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "read boundaries file")
+	}
+	collection := &struct {
+		Features []struct {
+			Properties struct {
+				TZID string `json:"tzid"`
+			} `json:"properties"`
+			Geometry struct {
+				Type        string          `json:"type"`
+				Coordinates json.RawMessage `json:"coordinates"`
+			} `json:"geometry"`
+		} `json:"features"`
+	}{}
+	err = json.Unmarshal(data, collection)
+	if err != nil {
+		return nil, errors.Wrap(err, "boundaries unmarshal")
+	}
+	finder := NewFinder()
+	for _, feature := range collection.Features {
+		var rawPolygons [][]ring
+		switch feature.Geometry.Type {
+		case "Polygon":
+			var rawPolygon []ring
+			err = json.Unmarshal(feature.Geometry.Coordinates, &rawPolygon)
+			rawPolygons = [][]ring{rawPolygon}
+		case "MultiPolygon":
+			err = json.Unmarshal(feature.Geometry.Coordinates, &rawPolygons)
+		default:
+			err = errors.Errorf("unsupported geometry %s", feature.Geometry.Type)
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "zone %s geometry", feature.Properties.TZID)
+		}
+		// the dataset can be newer than the embedded zones database
+		if _, err := timezones.Load(feature.Properties.TZID); err != nil {
+			continue
+		}
+		finder.areas = append(finder.areas, newZoneArea(feature.Properties.TZID, rawPolygons))
+	}
+	return finder, nil
+}
+
+func newZoneArea(zone string, rawPolygons [][]ring) *zoneArea {
+	area := &zoneArea{zone: zone, minLon: math.Inf(1), minLat: math.Inf(1), maxLon: math.Inf(-1),
+		maxLat: math.Inf(-1)}
+	for _, rings := range rawPolygons {
+		if len(rings) == 0 {
+			continue
+		}
+		for _, point := range rings[0] {
+			area.minLon, area.maxLon = math.Min(area.minLon, point[0]), math.Max(area.maxLon, point[0])
+			area.minLat, area.maxLat = math.Min(area.minLat, point[1]), math.Max(area.maxLat, point[1])
+		}
+		area.polygons = append(area.polygons, &polygon{outer: rings[0], holes: rings[1:]})
+	}
+	return area
+}
+
+// Lookup returns the zone name for the coordinates, it's a fixed offset like "+05:00" for remote places.
+// The second result is false if the zone isn't found by the boundaries, the nearest city can be
+// in another zone near borders.
+func (f *Finder) Lookup(latitude, longitude float64) (string, bool) {
+	for _, area := range f.areas {
+		if area.contains(longitude, latitude) {
+			return area.zone, true
+		}
+	}
+	nearest, distance := "", math.Inf(1)
+	for _, point := range referencePoints {
+		d := distanceKm(latitude, longitude, point.latitude, point.longitude)
+		if d < distance {
+			nearest, distance = point.zone, d
+		}
+	}
+	if distance <= maxReferenceDistanceKm {
+		return nearest, false
+	}
+	return timezones.FormatOffset(time.Duration(math.Round(longitude/15)) * time.Hour), false
+}
+
+func (a *zoneArea) contains(lon, lat float64) bool {
+	if lon < a.minLon || lon > a.maxLon || lat < a.minLat || lat > a.maxLat {
+		return false
+	}
+	for _, p := range a.polygons {
+		if !p.outer.contains(lon, lat) {
+			continue
+		}
+		inHole := false
+		for _, hole := range p.holes {
+			if hole.contains(lon, lat) {
+				inHole = true
+				break
+			}
+		}
+		if !inHole {
+			return true
+		}
+	}
+	return false
+}
+
+// contains uses the ray casting algorithm.
+func (r ring) contains(lon, lat float64) bool {
+	inside := false
+	for i, j := 0, len(r)-1; i < len(r); j, i = i, i+1 {
+		xi, yi, xj, yj := r[i][0], r[i][1], r[j][0], r[j][1]
+		if (yi > lat) != (yj > lat) && lon < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+func distanceKm(lat1, lon1, lat2, lon2 float64) float64 {
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	dLat, dLon := toRad(lat2-lat1), toRad(lon2-lon1)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRad(lat1))*math.Cos(toRad(lat2))*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadiusKm * math.Asin(math.Sqrt(h))
+}
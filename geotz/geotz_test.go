@@ -0,0 +1,95 @@
+package geotz
+
+import (
+	"testing"
+)
+
+func TestRingContains(t *testing.T) {
+	// an L shape, the notch at the top right is outside
+	shape := ring{{0, 0}, {4, 0}, {4, 2}, {2, 2}, {2, 4}, {0, 4}, {0, 0}}
+	cases := []struct {
+		name     string
+		lon, lat float64
+		expected bool
+	}{
+		{"inside", 1, 1, true},
+		{"inside the vertical part", 1, 3, true},
+		{"inside the horizontal part", 3, 1, true},
+		{"in the notch", 3, 3, false},
+		{"left", -1, 1, false},
+		{"below", 1, -1, false},
+		{"far away", 10, 10, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if result := shape.contains(c.lon, c.lat); result != c.expected {
+				t.Errorf("expected %t, got %t", c.expected, result)
+			}
+		})
+	}
+}
+
+func TestZoneAreaContains(t *testing.T) {
+	outer := ring{{0, 0}, {10, 0}, {10, 10}, {0, 10}, {0, 0}}
+	hole := ring{{4, 4}, {6, 4}, {6, 6}, {4, 6}, {4, 4}}
+	island := ring{{20, 20}, {22, 20}, {22, 22}, {20, 22}, {20, 20}}
+	area := newZoneArea("Europe/Berlin", [][]ring{{outer, hole}, {island}})
+	cases := []struct {
+		name     string
+		lon, lat float64
+		expected bool
+	}{
+		{"inside", 2, 2, true},
+		{"in the hole", 5, 5, false},
+		{"between the hole and the border", 7, 5, true},
+		{"on the island", 21, 21, true},
+		{"between the polygons", 15, 15, false},
+		{"outside of the bounding box", -5, 5, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if result := area.contains(c.lon, c.lat); result != c.expected {
+				t.Errorf("expected %t, got %t", c.expected, result)
+			}
+		})
+	}
+}
+
+func TestLookup(t *testing.T) {
+	withBoundaries, err := LoadBoundaries("testdata/boundaries.json")
+	if err != nil {
+		t.Fatalf("load boundaries: %s", err)
+	}
+	if len(withBoundaries.areas) != 3 {
+		t.Fatalf("expected the unknown zone to be skipped, got %d areas", len(withBoundaries.areas))
+	}
+	cases := []struct {
+		name          string
+		finder        *Finder
+		lat, lon      float64
+		expected      string
+		expectedExact bool
+	}{
+		{"boundaries", withBoundaries, 53, 7, "Europe/Berlin", true},
+		{"hole of the boundaries", withBoundaries, 51, 10, "Europe/Prague", true},
+		{"second polygon", withBoundaries, 39.5, 4, "Europe/Madrid", true},
+		{"outside of the boundaries", withBoundaries, 35.7, 139.7, "Asia/Tokyo", false},
+		{"nearest city", NewFinder(), 52.4, 13.1, "Europe/Berlin", false},
+		{"ocean", NewFinder(), 0, -140, "-09:00", false},
+		{"ocean east", NewFinder(), -40, 100, "+07:00", false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			zone, exact := c.finder.Lookup(c.lat, c.lon)
+			if zone != c.expected || exact != c.expectedExact {
+				t.Errorf("expected %s %t, got %s %t", c.expected, c.expectedExact, zone, exact)
+			}
+		})
+	}
+}
+
+func TestLoadBoundariesErrors(t *testing.T) {
+	if _, err := LoadBoundaries("testdata/missing.json"); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
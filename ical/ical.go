@@ -0,0 +1,350 @@
+// Package ical reads and writes a subset of RFC 5545 calendars: events with their alarms,
+// recurrence rules and categories. Other components, e.g to-dos and timezone definitions, are skipped.
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	dateTimeFormat    = "20060102T150405"
+	utcDateTimeFormat = "20060102T150405Z"
+	dateFormat        = "20060102"
+	prodID            = "-//reminder//reminder bot//EN"
+	// maxLineLength is in octets, longer lines are folded
+	maxLineLength = 75
+	maxLineSize   = 1 << 20
+)
+
+var (
+	textEscaper   = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+	textUnescaper = strings.NewReplacer(`\\`, `\`, `\;`, ";", `\,`, ",", `\n`, "\n", `\N`, "\n")
+)
+
+// Event.Start is in the zone of the event. Floating and all-day events don't have a zone,
+// their wall clock is put to the location given to Decode. Alarms are how long before the start they trigger.
+type Event struct {
+	UID         string
+	Summary     string
+	Description string
+	Start       time.Time
+	AllDay      bool
+	RRule       string
+	Categories  []string
+	Alarms      []time.Duration
+}
+
+// property is a content line like "DTSTART;TZID=Europe/Berlin:20300102T150405".
+type property struct {
+	name   string
+	params map[string]string
+	value  string
+}
+
+// Decode reads events of the calendar, exceptions of recurring events (with RECURRENCE-ID) are skipped.
+// Zones unknown to the zones database are treated as loc.
+func Decode(r io.Reader, loc *time.Location) ([]*Event, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 || !strings.EqualFold(lines[0], "BEGIN:VCALENDAR") {
+		return nil, errors.New("not an iCalendar file")
+	}
+	var events []*Event
+	var event *Event
+	var components []string
+	isException := false
+	for i, line := range lines {
+		prop, err := parseProperty(line)
+		if err != nil {
+			return nil, errors.Wrapf(err, "line %d", i+1)
+		}
+		switch prop.name {
+		case "BEGIN":
+			components = append(components, strings.ToUpper(prop.value))
+			if len(components) == 2 && components[1] == "VEVENT" {
+				event, isException = &Event{}, false
+			}
+			continue
+		case "END":
+			if len(components) == 0 {
+				return nil, errors.Errorf("line %d: unexpected end of %s", i+1, prop.value)
+			}
+			if len(components) == 2 && components[1] == "VEVENT" {
+				if event.Start.IsZero() {
+					return nil, errors.Errorf("line %d: event %q without start", i+1, event.Summary)
+				}
+				if !isException {
+					events = append(events, event)
+				}
+				event = nil
+			}
+			components = components[:len(components)-1]
+			continue
+		}
+		if event == nil {
+			continue
+		}
+		if len(components) == 3 && components[2] == "VALARM" {
+			if prop.name == "TRIGGER" {
+				if before, ok := parseTrigger(prop); ok {
+					event.Alarms = append(event.Alarms, before)
+				}
+			}
+			continue
+		}
+		if len(components) != 2 {
+			continue
+		}
+		switch prop.name {
+		case "UID":
+			event.UID = prop.value
+		case "SUMMARY":
+			event.Summary = textUnescaper.Replace(prop.value)
+		case "DESCRIPTION":
+			event.Description = textUnescaper.Replace(prop.value)
+		case "DTSTART":
+			event.Start, event.AllDay, err = parseStart(prop, loc)
+			if err != nil {
+				return nil, errors.Wrapf(err, "line %d", i+1)
+			}
+		case "RRULE":
+			event.RRule = prop.value
+		case "CATEGORIES":
+			for _, category := range splitList(prop.value) {
+				event.Categories = append(event.Categories, textUnescaper.Replace(category))
+			}
+		case "RECURRENCE-ID":
+			isException = true
+		}
+	}
+	if len(components) != 0 {
+		return nil, errors.Errorf("%s isn't closed", components[len(components)-1])
+	}
+	return events, nil
+}
+
+// Encode writes events as a calendar. Starts in IANA zones keep the zone, other starts are written in UTC.
+func Encode(w io.Writer, events []*Event, now time.Time) error {
+	bw := bufio.NewWriter(w)
+	writeLine := func(line string) {
+		// continuation lines start with a space, so they fit one octet less of the line
+		limit := maxLineLength
+		for len(line) > limit {
+			cut := limit
+			for !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			bw.WriteString(line[:cut] + "\r\n ")
+			line = line[cut:]
+			limit = maxLineLength - 1
+		}
+		bw.WriteString(line + "\r\n")
+	}
+	writeLine("BEGIN:VCALENDAR")
+	writeLine("VERSION:2.0")
+	writeLine("PRODID:" + prodID)
+	writeLine("CALSCALE:GREGORIAN")
+	writeLine("METHOD:PUBLISH")
+	for _, event := range events {
+		writeLine("BEGIN:VEVENT")
+		writeLine("UID:" + event.UID)
+		writeLine("DTSTAMP:" + now.UTC().Format(utcDateTimeFormat))
+		writeLine(formatStart(event))
+		writeLine("SUMMARY:" + textEscaper.Replace(event.Summary))
+		if event.Description != "" {
+			writeLine("DESCRIPTION:" + textEscaper.Replace(event.Description))
+		}
+		if len(event.Categories) != 0 {
+			categories := make([]string, len(event.Categories))
+			for i, category := range event.Categories {
+				categories[i] = textEscaper.Replace(category)
+			}
+			writeLine("CATEGORIES:" + strings.Join(categories, ","))
+		}
+		if event.RRule != "" {
+			writeLine("RRULE:" + event.RRule)
+		}
+		for _, before := range event.Alarms {
+			writeLine("BEGIN:VALARM")
+			writeLine("ACTION:DISPLAY")
+			writeLine("DESCRIPTION:" + textEscaper.Replace(event.Summary))
+			writeLine("TRIGGER:" + formatDuration(-before))
+			writeLine("END:VALARM")
+		}
+		writeLine("END:VEVENT")
+	}
+	writeLine("END:VCALENDAR")
+	return errors.Wrap(bw.Flush(), "calendar write")
+}
+
+// unfold joins folded content lines, empty lines are dropped.
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), maxLineSize)
+	var lines []string
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && len(lines) != 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if line != "" {
+			lines = append(lines, strings.TrimPrefix(line, "\ufeff"))
+		}
+	}
+	return lines, errors.Wrap(scanner.Err(), "calendar read")
+}
+
+func parseProperty(line string) (*property, error) {
+	inQuotes, colon := false, -1
+	for i, r := range line {
+		if r == '"' {
+			inQuotes = !inQuotes
+		} else if r == ':' && !inQuotes {
+			colon = i
+			break
+		}
+	}
+	if colon == -1 {
+		return nil, errors.Errorf("content line without a value %q", line)
+	}
+	parts := strings.Split(line[:colon], ";")
+	prop := &property{name: strings.ToUpper(parts[0]), params: map[string]string{}, value: line[colon+1:]}
+	for _, param := range parts[1:] {
+		if idx := strings.Index(param, "="); idx != -1 {
+			prop.params[strings.ToUpper(param[:idx])] = strings.Trim(param[idx+1:], `"`)
+		}
+	}
+	return prop, nil
+}
+
+// splitList splits a comma separated value, escaped commas are kept.
+func splitList(value string) []string {
+	var items []string
+	start := 0
+	for i := 0; i < len(value); i++ {
+		if value[i] == '\\' {
+			i++
+		} else if value[i] == ',' {
+			items = append(items, value[start:i])
+			start = i + 1
+		}
+	}
+	return append(items, value[start:])
+}
+
+func parseStart(prop *property, loc *time.Location) (time.Time, bool, error) {
+	if strings.EqualFold(prop.params["VALUE"], "DATE") || len(prop.value) == len(dateFormat) {
+		start, err := time.ParseInLocation(dateFormat, prop.value, loc)
+		return start, true, errors.Wrapf(err, "bad date %q", prop.value)
+	}
+	if strings.HasSuffix(prop.value, "Z") {
+		start, err := time.Parse(utcDateTimeFormat, prop.value)
+		return start, false, errors.Wrapf(err, "bad utc time %q", prop.value)
+	}
+	if tzid := prop.params["TZID"]; tzid != "" {
+		if zone, err := time.LoadLocation(strings.TrimPrefix(tzid, "/")); err == nil {
+			loc = zone
+		}
+	}
+	start, err := time.ParseInLocation(dateTimeFormat, prop.value, loc)
+	return start, false, errors.Wrapf(err, "bad time %q", prop.value)
+}
+
+func formatStart(event *Event) string {
+	if event.AllDay {
+		return "DTSTART;VALUE=DATE:" + event.Start.Format(dateFormat)
+	}
+	if name := event.Start.Location().String(); strings.Contains(name, "/") {
+		return fmt.Sprintf("DTSTART;TZID=%s:%s", name, event.Start.Format(dateTimeFormat))
+	}
+	return "DTSTART:" + event.Start.UTC().Format(utcDateTimeFormat)
+}
+
+// parseTrigger returns how long before the start the alarm triggers, alarms at an absolute time,
+// relative to the end or after the start aren't supported.
+func parseTrigger(prop *property) (time.Duration, bool) {
+	if strings.EqualFold(prop.params["VALUE"], "DATE-TIME") || strings.EqualFold(prop.params["RELATED"], "END") {
+		return 0, false
+	}
+	offset, err := parseDuration(prop.value)
+	if err != nil || offset > 0 {
+		return 0, false
+	}
+	return -offset, true
+}
+
+// parseDuration parses durations like "-PT15M", "P1D" or "-P1W".
+func parseDuration(s string) (time.Duration, error) {
+	sign := time.Duration(1)
+	if strings.HasPrefix(s, "-") {
+		sign = -1
+	}
+	s = strings.TrimLeft(s, "+-")
+	if !strings.HasPrefix(s, "P") || len(s) < 3 {
+		return 0, errors.Errorf("bad duration %q", s)
+	}
+	var total time.Duration
+	inTime, number, hasNumber := false, 0, false
+	for _, r := range s[1:] {
+		switch {
+		case r >= '0' && r <= '9':
+			number, hasNumber = number*10+int(r-'0'), true
+			continue
+		case r == 'T':
+			inTime = true
+			continue
+		case r == 'W' && !inTime:
+			total += time.Duration(number) * 7 * 24 * time.Hour
+		case r == 'D' && !inTime:
+			total += time.Duration(number) * 24 * time.Hour
+		case r == 'H' && inTime:
+			total += time.Duration(number) * time.Hour
+		case r == 'M' && inTime:
+			total += time.Duration(number) * time.Minute
+		case r == 'S' && inTime:
+			total += time.Duration(number) * time.Second
+		default:
+			return 0, errors.Errorf("bad duration %q", s)
+		}
+		number, hasNumber = 0, false
+	}
+	// a number without a unit
+	if hasNumber {
+		return 0, errors.Errorf("bad duration %q", s)
+	}
+	return sign * total, nil
+}
+
+func formatDuration(d time.Duration) string {
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	if d == 0 {
+		return "PT0S"
+	}
+	if d%(24*time.Hour) == 0 {
+		return fmt.Sprintf("%sP%dD", sign, d/(24*time.Hour))
+	}
+	s := sign + "PT"
+	if hours := d / time.Hour; hours > 0 {
+		s += fmt.Sprintf("%dH", hours)
+	}
+	if minutes := d % time.Hour / time.Minute; minutes > 0 {
+		s += fmt.Sprintf("%dM", minutes)
+	}
+	if seconds := d % time.Minute / time.Second; seconds > 0 {
+		s += fmt.Sprintf("%dS", seconds)
+	}
+	return s
+}
@@ -0,0 +1,177 @@
+package ical_test
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"reminder/ical"
+)
+
+func loadLocation(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("load location: %s", err)
+	}
+	return loc
+}
+
+func TestEncodeDecode(t *testing.T) {
+	berlin := loadLocation(t, "Europe/Berlin")
+	newYork := loadLocation(t, "America/New_York")
+	moscow := loadLocation(t, "Europe/Moscow")
+	events := []*ical.Event{
+		{
+			UID:         "recurring@reminder",
+			Summary:     "Weekly sync; agenda, notes and a rather long title that gets folded — twice, probably",
+			Description: "first line\nsecond line",
+			Start:       time.Date(2030, 3, 4, 10, 30, 0, 0, berlin),
+			RRule:       "FREQ=WEEKLY;BYDAY=MO;COUNT=5",
+			Categories:  []string{"work", "team, all"},
+			Alarms:      []time.Duration{0, 15 * time.Minute, 24 * time.Hour},
+		},
+		{
+			UID:     "all-day@reminder",
+			Summary: "Holiday",
+			Start:   time.Date(2030, 5, 1, 0, 0, 0, 0, moscow),
+			AllDay:  true,
+		},
+		{
+			UID:     "other-zone@reminder",
+			Summary: "Call",
+			Start:   time.Date(2030, 11, 3, 1, 30, 0, 0, newYork),
+			Alarms:  []time.Duration{90 * time.Minute},
+		},
+		{
+			UID:         "long-summary@reminder",
+			Summary:     strings.Repeat("Позвонить в банк и уточнить ", 12),
+			Description: strings.Repeat("x", 300),
+			Start:       time.Date(2030, 8, 1, 9, 0, 0, 0, berlin),
+		},
+		{
+			UID:     "fixed-offset@reminder",
+			Summary: "Fixed offset",
+			Start:   time.Date(2030, 7, 1, 9, 0, 0, 0, time.FixedZone("UTC+03:00", 3*60*60)),
+		},
+	}
+	content := &bytes.Buffer{}
+	err := ical.Encode(content, events, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatalf("encode: %s", err)
+	}
+	for _, line := range strings.Split(content.String(), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line %q is %d octets long, the limit is 75", line, len(line))
+		}
+	}
+	decoded, err := ical.Decode(content, moscow)
+	if err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+	if len(decoded) != len(events) {
+		t.Fatalf("expected %d events, got %d", len(events), len(decoded))
+	}
+	for i, expected := range events {
+		event := decoded[i]
+		t.Run(expected.UID, func(t *testing.T) {
+			if event.UID != expected.UID || event.Summary != expected.Summary ||
+				event.Description != expected.Description || event.RRule != expected.RRule ||
+				event.AllDay != expected.AllDay {
+				t.Errorf("expected %+v, got %+v", expected, event)
+			}
+			if !reflect.DeepEqual(event.Categories, expected.Categories) {
+				t.Errorf("expected categories %q, got %q", expected.Categories, event.Categories)
+			}
+			if !reflect.DeepEqual(event.Alarms, expected.Alarms) {
+				t.Errorf("expected alarms %v, got %v", expected.Alarms, event.Alarms)
+			}
+			if !event.Start.Equal(expected.Start) {
+				t.Errorf("expected start %s, got %s", expected.Start, event.Start)
+			}
+		})
+	}
+	if name := decoded[0].Start.Location().String(); name != "Europe/Berlin" {
+		t.Errorf("expected the start in Europe/Berlin, got %s", name)
+	}
+	if name := decoded[2].Start.Location().String(); name != "America/New_York" {
+		t.Errorf("expected the start in America/New_York, got %s", name)
+	}
+}
+
+func TestDecodeZones(t *testing.T) {
+	moscow := loadLocation(t, "Europe/Moscow")
+	calendar := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"BEGIN:VEVENT",
+		"UID:tzid",
+		"DTSTART;TZID=America/New_York:20300103T090000",
+		"BEGIN:VALARM",
+		"TRIGGER:-PT30M",
+		"END:VALARM",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:floating",
+		"DTSTART:20300103T090000",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:unknown-zone",
+		"DTSTART;TZID=Customized Time Zone:20300103T090000",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:utc",
+		"DTSTART:20300103T090000Z",
+		"END:VEVENT",
+		"BEGIN:VEVENT",
+		"UID:all-day",
+		"DTSTART;VALUE=DATE:20300103",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n")
+	events, err := ical.Decode(strings.NewReader(calendar), moscow)
+	if err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+	expected := map[string]time.Time{
+		"tzid":         time.Date(2030, 1, 3, 14, 0, 0, 0, time.UTC),
+		"floating":     time.Date(2030, 1, 3, 6, 0, 0, 0, time.UTC),
+		"unknown-zone": time.Date(2030, 1, 3, 6, 0, 0, 0, time.UTC),
+		"utc":          time.Date(2030, 1, 3, 9, 0, 0, 0, time.UTC),
+		"all-day":      time.Date(2030, 1, 2, 21, 0, 0, 0, time.UTC),
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("expected %d events, got %d", len(expected), len(events))
+	}
+	for _, event := range events {
+		if !event.Start.Equal(expected[event.UID]) {
+			t.Errorf("%s: expected start %s, got %s", event.UID, expected[event.UID], event.Start.UTC())
+		}
+	}
+	if !reflect.DeepEqual(events[0].Alarms, []time.Duration{30 * time.Minute}) {
+		t.Errorf("expected a 30 minutes alarm, got %v", events[0].Alarms)
+	}
+	if !events[4].AllDay {
+		t.Error("expected an all-day event")
+	}
+}
+
+func TestDecodeTriggers(t *testing.T) {
+	lines := []string{"BEGIN:VCALENDAR", "BEGIN:VEVENT", "UID:alarms", "DTSTART:20300103T090000Z"}
+	for _, trigger := range []string{"-PT15M", "-P1DT2H", "PT0S", "-PT15", "-P1", "PT5M", "-PX",
+		"-PT1H30"} {
+		lines = append(lines, "BEGIN:VALARM", "TRIGGER:"+trigger, "END:VALARM")
+	}
+	lines = append(lines, "BEGIN:VALARM", "TRIGGER;RELATED=END:-PT5M", "END:VALARM", "END:VEVENT", "END:VCALENDAR")
+	events, err := ical.Decode(strings.NewReader(strings.Join(lines, "\r\n")), time.UTC)
+	if err != nil {
+		t.Fatalf("decode: %s", err)
+	}
+	expected := []time.Duration{15 * time.Minute, 26 * time.Hour, 0}
+	if len(events) != 1 {
+		t.Fatalf("expected one event, got %d", len(events))
+	}
+	if !reflect.DeepEqual(events[0].Alarms, expected) {
+		t.Errorf("expected alarms %v, got %v", expected, events[0].Alarms)
+	}
+}
@@ -1,6 +1,10 @@
 package models
 
 import (
+	"regexp"
+	"reminder/recurrence"
+	"reminder/timezones"
+	"strings"
 	"time"
 
 	"github.com/gazoon/bot_libs/logging"
@@ -8,28 +12,51 @@ import (
 )
 
 const (
-	DefaultTimezone = 10800
+	DefaultTimezone = "Europe/Moscow"
 )
 
+var (
+	tagRegexp      = regexp.MustCompile(`(?:^|\s)#([\p{L}\p{N}_-]+)`)
+	validTagRegexp = regexp.MustCompile(`^[\p{L}\p{N}_-]+$`)
+	// mentionRegexp matches telegram usernames, they are case insensitive
+	mentionRegexp       = regexp.MustCompile(`(?:^|\s)@([A-Za-z0-9_]{1,32})\b`)
+	validUsernameRegexp = regexp.MustCompile(`^[A-Za-z0-9_]{1,32}$`)
+)
+
+// Archive kinds, archived reminders are kept for the history and never fire.
+const (
+	ArchivedFired   = "fired"
+	ArchivedDone    = "done"
+	ArchivedDeleted = "deleted"
+)
+
+// Chat keeps the timezone as an IANA zone name, e.g "Europe/Berlin", or a fixed offset like "+05:30".
 type Chat struct {
 	ID       int
-	Timezone int
+	Timezone string
 }
 
-func NewChat(chatID, timezone int) *Chat {
+func NewChat(chatID int, timezone string) *Chat {
 	return &Chat{ID: chatID, Timezone: timezone}
 }
 
-func (c *Chat) timeDelta() time.Duration {
-	return time.Hour * time.Duration(c.Timezone)
+// Location falls back to UTC for unknown zones, storages validate zones when chats are loaded.
+func (c *Chat) Location() *time.Location {
+	loc, err := timezones.Load(c.Timezone)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
 }
 
 func (c *Chat) ToLocalTime(t time.Time) time.Time {
-	return t.Add(c.timeDelta())
+	return t.In(c.Location())
 }
 
+// ToUTC interprets the wall clock of t in the chat timezone.
 func (c *Chat) ToUTC(t time.Time) time.Time {
-	return t.Add(-c.timeDelta())
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(),
+		c.Location()).UTC()
 }
 
 type Reminder struct {
@@ -40,6 +67,44 @@ type Reminder struct {
 	CreatedAt time.Time
 
 	Description *string
+	Recurrence  *recurrence.Rule
+	FiredCount  int
+	Tags        []string
+
+	// CreatorID and AssigneeID are user ids, they are zero for reminders created before group chats support.
+	// Assignee is the lowercased username of the assigned member, the member's id is known only
+	// if they assigned the reminder themselves
+	CreatorID  int
+	AssigneeID int
+	Assignee   string
+
+	// NagInterval repeats the fired reminder until it's acknowledged, NagMaxRepeats limits repeats if it's set
+	NagInterval   time.Duration
+	NagMaxRepeats int
+	// AckPending is set while the fired occurrence isn't acknowledged, OccurrenceAt keeps its original time
+	AckPending   bool
+	NagRepeats   int
+	OccurrenceAt *time.Time
+
+	// NotifyBefore lists advance notifications before the remind time. NotifyAt is when the reminder fires next,
+	// NotifyAhead is how long before the remind time it is, it's zero for the due notification itself
+	NotifyBefore []time.Duration
+	NotifyAt     time.Time
+	NotifyAhead  time.Duration
+
+	// ArchivedAs is the archive kind, it's empty for active reminders
+	ArchivedAs string
+	ArchivedAt *time.Time
+
+	// DeliveryStatus is the delivery state of the storage, saving the reminder keeps it, see Reschedule.
+	// Attempts and LeaseUntil identify the delivery attempt of a reminder claimed by the reader
+	DeliveryStatus string
+	Attempts       int
+	LeaseUntil     time.Time
+	// Failed is set if the delivery failed after all attempts, LastError is why. The failed reminder isn't delivered
+	// until it's rescheduled
+	Failed    bool
+	LastError string
 }
 
 func NewReminder(chatID int, title string, remindAt time.Time, description *string) *Reminder {
@@ -53,6 +118,227 @@ func NewReminder(chatID int, title string, remindAt time.Time, description *stri
 	}
 }
 
+// NextOccurrence returns the next remind time of the recurring reminder in the chat timezone,
+// the second result is false if the reminder isn't recurring or the recurrence is over.
+func (r *Reminder) NextOccurrence(chat *Chat) (time.Time, bool) {
+	if r.Recurrence == nil {
+		return time.Time{}, false
+	}
+	loc := time.UTC
+	if chat != nil {
+		loc = chat.Location()
+	}
+	return r.Recurrence.Next(r.RemindAt, r.FiredCount+1, loc)
+}
+
+// Advance moves the recurring reminder to the next occurrence, it returns false if there is no next occurrence.
+// The time of day of the first advanced occurrence is kept by the rule, so later ones don't drift
+// after an occurrence moved by a DST gap.
+func (r *Reminder) Advance(chat *Chat) bool {
+	if r.Recurrence != nil && r.Recurrence.TimeOfDay == nil {
+		loc := time.UTC
+		if chat != nil {
+			loc = chat.Location()
+		}
+		local := r.RemindAt.In(loc)
+		timeOfDay := time.Duration(local.Hour())*time.Hour + time.Duration(local.Minute())*time.Minute +
+			time.Duration(local.Second())*time.Second
+		r.Recurrence.TimeOfDay = &timeOfDay
+	}
+	next, ok := r.NextOccurrence(chat)
+	if !ok {
+		return false
+	}
+	r.RemindAt = next
+	r.FiredCount++
+	return true
+}
+
+// AdvanceAfter moves the recurring reminder to the first occurrence after now, occurrences missed in between,
+// e.g while the sender was down, are skipped. The reminder isn't changed if there is no such occurrence.
+func (r *Reminder) AdvanceAfter(chat *Chat, now time.Time) bool {
+	remindAt, firedCount := r.RemindAt, r.FiredCount
+	for r.Advance(chat) {
+		if r.RemindAt.After(now) {
+			return true
+		}
+	}
+	r.RemindAt, r.FiredCount = remindAt, firedCount
+	return false
+}
+
+// Nag schedules the next repeat of the fired reminder, it returns false if the reminder doesn't nag
+// or repeats are over, the nagging state is reset then.
+func (r *Reminder) Nag(now time.Time) bool {
+	if r.NagInterval <= 0 {
+		return false
+	}
+	if !r.AckPending {
+		occurrenceAt := r.RemindAt
+		r.AckPending, r.OccurrenceAt, r.NagRepeats = true, &occurrenceAt, 0
+	} else {
+		r.NagRepeats++
+	}
+	if r.NagMaxRepeats > 0 && r.NagRepeats >= r.NagMaxRepeats {
+		r.Acknowledge()
+		return false
+	}
+	r.RemindAt = now.Add(r.NagInterval)
+	return true
+}
+
+// Acknowledge stops nagging and restores the occurrence time, so recurrence continues from it.
+func (r *Reminder) Acknowledge() {
+	if r.OccurrenceAt != nil {
+		r.RemindAt = *r.OccurrenceAt
+	}
+	r.AckPending, r.OccurrenceAt, r.NagRepeats = false, nil, 0
+}
+
+// NextNotification returns the time of the first notification after now and how long before the remind time
+// it is. Advance notifications aren't sent while the fired reminder waits for acknowledgement.
+func (r *Reminder) NextNotification(now time.Time) (time.Time, time.Duration) {
+	notifyAt, ahead := r.RemindAt, time.Duration(0)
+	if r.AckPending {
+		return notifyAt, ahead
+	}
+	for _, before := range r.NotifyBefore {
+		if before > ahead && r.RemindAt.Add(-before).After(now) {
+			notifyAt, ahead = r.RemindAt.Add(-before), before
+		}
+	}
+	return notifyAt, ahead
+}
+
+// IsAdvanceNotification reports whether the reminder fires ahead of the remind time, a late advance
+// notification is delivered as the due one.
+func (r *Reminder) IsAdvanceNotification(now time.Time) bool {
+	return r.NotifyAhead > 0 && r.RemindAt.After(now)
+}
+
+// AddTags appends tags the reminder doesn't have yet.
+func (r *Reminder) AddTags(tags ...string) {
+	for _, tag := range tags {
+		if !r.HasTag(tag) {
+			r.Tags = append(r.Tags, tag)
+		}
+	}
+}
+
+func (r *Reminder) HasTag(tag string) bool {
+	for _, t := range r.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// ExtractTags removes #tags from the text, tags are lowercased and deduplicated.
+func ExtractTags(text string) (string, []string) {
+	var tags []string
+	seen := map[string]bool{}
+	for _, match := range tagRegexp.FindAllStringSubmatch(text, -1) {
+		tag := strings.ToLower(match[1])
+		if !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	text = strings.Join(strings.Fields(tagRegexp.ReplaceAllString(text, "")), " ")
+	return text, tags
+}
+
+// ExtractMention removes the first @username from the text, the username is lowercased.
+func ExtractMention(text string) (string, string) {
+	match := mentionRegexp.FindStringSubmatchIndex(text)
+	if match == nil {
+		return text, ""
+	}
+	username := strings.ToLower(text[match[2]:match[3]])
+	text = strings.Join(strings.Fields(text[:match[0]]+" "+text[match[1]:]), " ")
+	return text, username
+}
+
+// NormalizeUsername returns the username without the leading @ in lower case,
+// the second result is false for invalid usernames.
+func NormalizeUsername(username string) (string, bool) {
+	username = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(username), "@"))
+	return username, validUsernameRegexp.MatchString(username)
+}
+
+// Assign sets the assignee, userID is zero if the id of the member is unknown.
+func (r *Reminder) Assign(userID int, username string) {
+	r.AssigneeID, r.Assignee = userID, strings.ToLower(username)
+}
+
+func (r *Reminder) IsAssigned() bool {
+	return r.AssigneeID != 0 || r.Assignee != ""
+}
+
+// BelongsTo reports whether the reminder is assigned to the user, unassigned reminders belong to their creator.
+func (r *Reminder) BelongsTo(userID int, username string) bool {
+	if !r.IsAssigned() {
+		return userID != 0 && r.CreatorID == userID
+	}
+	return (userID != 0 && r.AssigneeID == userID) || (username != "" && r.Assignee == strings.ToLower(username))
+}
+
+// Mention returns "@username" of the assignee, it's empty if the assignee has no username.
+func (r *Reminder) Mention() string {
+	if r.Assignee == "" {
+		return ""
+	}
+	return "@" + r.Assignee
+}
+
+// NormalizeTag returns the tag without the leading # in lower case, the second result is false for invalid tags.
+func NormalizeTag(tag string) (string, bool) {
+	tag = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(tag), "#"))
+	return tag, validTagRegexp.MatchString(tag)
+}
+
+// Reschedule drops the delivery state, so the saved reminder is delivered at its notification time
+// with a fresh attempts counter, e.g a failed one or the claimed one moved to the next occurrence.
+func (r *Reminder) Reschedule() {
+	r.DeliveryStatus, r.Attempts, r.LeaseUntil, r.Failed, r.LastError = "", 0, time.Time{}, false, ""
+}
+
+func (r *Reminder) IsArchived() bool {
+	return r.ArchivedAs != ""
+}
+
+func (r *Reminder) Archive(kind string, now time.Time) {
+	r.ArchivedAs, r.ArchivedAt = kind, &now
+}
+
+// Restore moves the reminder out of the archive, it's scheduled again.
+func (r *Reminder) Restore() {
+	r.ArchivedAs, r.ArchivedAt = "", nil
+	r.Reschedule()
+}
+
+// ArchivedOccurrence returns a copy of the current occurrence of a recurring reminder for the history,
+// the reminder itself stays active.
+func (r *Reminder) ArchivedOccurrence(kind string, now time.Time) *Reminder {
+	occurrence := NewReminder(r.ChatID, r.Title, r.RemindAt, r.Description)
+	occurrence.CreatedAt = r.CreatedAt
+	occurrence.CreatorID, occurrence.AssigneeID, occurrence.Assignee = r.CreatorID, r.AssigneeID, r.Assignee
+	occurrence.Archive(kind, now)
+	return occurrence
+}
+
+// OneOffCopy returns a new active reminder with the content and the settings of the reminder at remindAt,
+// e.g a snoozed occurrence of a recurring reminder.
+func (r *Reminder) OneOffCopy(remindAt time.Time) *Reminder {
+	reminder := NewReminder(r.ChatID, r.Title, remindAt, r.Description)
+	reminder.Tags = append([]string(nil), r.Tags...)
+	reminder.CreatorID, reminder.AssigneeID, reminder.Assignee = r.CreatorID, r.AssigneeID, r.Assignee
+	reminder.NagInterval, reminder.NagMaxRepeats = r.NagInterval, r.NagMaxRepeats
+	reminder.NotifyBefore = append([]time.Duration(nil), r.NotifyBefore...)
+	return reminder
+}
+
 func (r *Reminder) RemindAtLocal(chat *Chat) time.Time {
 	return chat.ToLocalTime(r.RemindAt)
 }
@@ -0,0 +1,27 @@
+package pages
+
+import (
+	"reminder/core"
+	"reminder/core/page"
+)
+
+type Back struct {
+	*page.BasePage
+}
+
+func (b *Back) Init(builder *page.PagesBuilder) error {
+	controllers := map[string]page.Controller{
+		"back": b.backController,
+	}
+	var err error
+	b.BasePage, err = builder.NewBasePage("back", nil, controllers)
+	return err
+}
+
+func (b *Back) backController(req *core.Request) (map[string]interface{}, *core.URL, error) {
+	previous := req.Session.PopHistory(req.Ctx)
+	if previous == nil {
+		return nil, core.DefaultPageURL, nil
+	}
+	return nil, previous, nil
+}
@@ -0,0 +1,269 @@
+package pages
+
+import (
+	"bytes"
+	"context"
+	"reminder/core"
+	"reminder/core/page"
+	"reminder/ical"
+	"reminder/models"
+	"reminder/recurrence"
+	"reminder/storages/chats"
+	"reminder/storages/reminders"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/satori/go.uuid"
+)
+
+const (
+	calendarFileName    = "reminders.ics"
+	calendarFileExt     = ".ics"
+	maxCalendarFileSize = 1 << 20
+	maxImportedEvents   = 500
+	// exported events have uids like "<reminder id>@reminder", so their import to the same chat is detected
+	calendarUIDSuffix = "@reminder"
+	// all-day events are reminded in the morning of the day
+	allDayRemindHour      = 9
+	maxSkippedOccurrences = 10000
+)
+
+// importedIDNamespace makes ids of imported reminders from chat ids and event uids, so imports are idempotent.
+var importedIDNamespace = uuid.NewV5(uuid.NamespaceURL, "reminder:ical")
+
+// CalendarFiles sends and downloads files, the messenger supports only text messages.
+type CalendarFiles interface {
+	SendDocument(ctx context.Context, chatID int, fileName string, content []byte) (int, error)
+	DownloadFile(ctx context.Context, fileID string) ([]byte, error)
+}
+
+type Calendar struct {
+	*page.BasePage
+
+	Reminders reminders.Storage
+	Chats     chats.Storage
+	Files     CalendarFiles
+}
+
+func (c *Calendar) Init(builder *page.PagesBuilder) error {
+	var err error
+	controllers := map[string]page.Controller{
+		"export":  c.exportController,
+		"on_file": c.onFileController,
+	}
+	c.BasePage, err = builder.NewBasePage("calendar", nil, controllers)
+	return err
+}
+
+// exportController sends pending reminders of the chat as an .ics file, failed reminders aren't delivered
+// until they're rescheduled, so they aren't exported.
+func (c *Calendar) exportController(req *core.Request) (map[string]interface{}, *core.URL, error) {
+	active, err := c.Reminders.List(req.Ctx, req.ChatID, reminders.ListFilter{})
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "reminders storage list")
+	}
+	var list []*models.Reminder
+	for _, reminder := range active {
+		if !reminder.IsArchived() && !reminder.Failed {
+			list = append(list, reminder)
+		}
+	}
+	if len(list) == 0 {
+		return map[string]interface{}{"no_reminders": true}, nil, nil
+	}
+	chat, err := c.getChat(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if chat == nil {
+		chat = models.NewChat(req.ChatID, models.DefaultTimezone)
+	}
+	events := make([]*ical.Event, len(list))
+	for i, reminder := range list {
+		events[i] = reminderToEvent(reminder, chat)
+	}
+	content := &bytes.Buffer{}
+	err = ical.Encode(content, events, time.Now())
+	if err != nil {
+		return nil, nil, err
+	}
+	_, err = c.Files.SendDocument(req.Ctx, req.ChatID, calendarFileName, content.Bytes())
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "send calendar")
+	}
+	return map[string]interface{}{"exported": len(events)}, nil, nil
+}
+
+// onFileController creates reminders from upcoming events of the uploaded .ics file. Events imported before,
+// including ones exported from this chat, are skipped, as well as past events and unsupported recurrences.
+func (c *Calendar) onFileController(req *core.Request) (map[string]interface{}, *core.URL, error) {
+	document, ok := req.Msg.(*core.DocumentMessage)
+	if !ok {
+		return page.BadInputResponse("send the calendar as a file")
+	}
+	if !strings.HasSuffix(strings.ToLower(document.FileName), calendarFileExt) {
+		return page.BadInputResponse("only .ics files are supported")
+	}
+	if document.FileSize > maxCalendarFileSize {
+		return page.BadInputResponse("the file is too big")
+	}
+	chat, err := c.getChat(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	if chat == nil {
+		return map[string]interface{}{"no_timezone": true}, nil, nil
+	}
+	content, err := c.Files.DownloadFile(req.Ctx, document.FileID)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "download calendar")
+	}
+	if len(content) > maxCalendarFileSize {
+		return page.BadInputResponse("the file is too big")
+	}
+	events, err := ical.Decode(bytes.NewReader(content), chat.Location())
+	if err != nil {
+		c.GetLogger(req.Ctx).Warnf("Cannot decode uploaded calendar: %s", err)
+		return page.BadInputResponse("the file isn't a valid calendar")
+	}
+	if len(events) > maxImportedEvents {
+		return page.BadInputResponse("the calendar has too many events")
+	}
+	var imported, duplicates, skipped int
+	now := time.Now().UTC()
+	for _, event := range events {
+		reminder, ok := eventToReminder(event, chat, now)
+		if !ok {
+			skipped++
+			continue
+		}
+		isDuplicate, err := c.isImported(req, event, reminder.ID)
+		if err != nil {
+			return nil, nil, err
+		}
+		if isDuplicate {
+			duplicates++
+			continue
+		}
+		reminder.CreatorID = req.UserID
+		err = c.Reminders.Save(req.Ctx, reminder)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "reminders storage save")
+		}
+		imported++
+	}
+	return map[string]interface{}{"imported": imported, "duplicates": duplicates, "skipped": skipped}, nil, nil
+}
+
+// isImported reports whether the event has been already imported or it's exported from this chat.
+func (c *Calendar) isImported(req *core.Request, event *ical.Event, reminderID string) (bool, error) {
+	existing, err := c.Reminders.Get(req.Ctx, reminderID)
+	if err != nil {
+		return false, errors.Wrap(err, "reminders storage get")
+	}
+	if existing != nil {
+		return true, nil
+	}
+	if !strings.HasSuffix(event.UID, calendarUIDSuffix) {
+		return false, nil
+	}
+	original, err := c.Reminders.Get(req.Ctx, strings.TrimSuffix(event.UID, calendarUIDSuffix))
+	if err != nil {
+		return false, errors.Wrap(err, "reminders storage get")
+	}
+	return original != nil && original.ChatID == req.ChatID, nil
+}
+
+func (c *Calendar) getChat(req *core.Request) (*models.Chat, error) {
+	chat, err := c.Chats.Get(req.Ctx, req.ChatID)
+	return chat, errors.Wrap(err, "chats storage get")
+}
+
+// reminderToEvent puts the start to the chat timezone, so recurring events keep the time of day over DST changes.
+// The event of a recurring reminder starts from the current occurrence.
+func reminderToEvent(reminder *models.Reminder, chat *models.Chat) *ical.Event {
+	start := reminder.RemindAt
+	if reminder.AckPending && reminder.OccurrenceAt != nil {
+		start = *reminder.OccurrenceAt
+	}
+	event := &ical.Event{
+		UID:        reminder.ID + calendarUIDSuffix,
+		Summary:    reminder.Title,
+		Start:      chat.ToLocalTime(start),
+		Categories: reminder.Tags,
+		Alarms:     append([]time.Duration{0}, reminder.NotifyBefore...),
+	}
+	if reminder.Description != nil {
+		event.Description = *reminder.Description
+	}
+	if reminder.Recurrence != nil {
+		rule := *reminder.Recurrence
+		if rule.Count != 0 {
+			rule.Count -= reminder.FiredCount
+		}
+		event.RRule = rule.String()
+	}
+	return event
+}
+
+// eventToReminder returns false for past events and events that can't be reminders, e.g with unsupported
+// recurrence rules. Past recurring events start from the next occurrence.
+func eventToReminder(event *ical.Event, chat *models.Chat, now time.Time) (*models.Reminder, bool) {
+	title, tags, err := parseTitle(event.Summary)
+	if err != nil {
+		return nil, false
+	}
+	start := event.Start
+	if event.AllDay {
+		start = time.Date(start.Year(), start.Month(), start.Day(), allDayRemindHour, 0, 0, 0, chat.Location())
+	}
+	reminder := models.NewReminder(chat.ID, title, start.UTC(), parseDescription(event.Description))
+	reminder.ID = importedReminderID(chat.ID, event)
+	reminder.AddTags(tags...)
+	for _, category := range event.Categories {
+		if tag, ok := models.NormalizeTag(strings.Replace(category, " ", "_", -1)); ok {
+			reminder.AddTags(tag)
+		}
+	}
+	reminder.NotifyBefore = alarmsToNotifyBefore(event.Alarms)
+	if event.RRule == "" {
+		return reminder, reminder.RemindAt.After(now)
+	}
+	reminder.Recurrence, err = recurrence.Parse(event.RRule)
+	if err != nil {
+		return nil, false
+	}
+	for i := 0; !reminder.RemindAt.After(now); i++ {
+		if i == maxSkippedOccurrences || !reminder.Advance(chat) {
+			return nil, false
+		}
+	}
+	return reminder, true
+}
+
+func importedReminderID(chatID int, event *ical.Event) string {
+	uid := event.UID
+	if uid == "" {
+		uid = event.Start.UTC().Format(time.RFC3339) + "/" + event.Summary
+	}
+	return uuid.NewV5(importedIDNamespace, strconv.Itoa(chatID)+"/"+uid).String()
+}
+
+// alarmsToNotifyBefore keeps alarms the advance notifications support, alarms at the start are the reminder itself.
+func alarmsToNotifyBefore(alarms []time.Duration) []time.Duration {
+	var notifyBefore []time.Duration
+	seen := map[time.Duration]bool{}
+	for _, before := range alarms {
+		before = before.Truncate(time.Minute)
+		if before < time.Minute || before > maxNotifyBefore || seen[before] || len(notifyBefore) == maxNotifyBeforeItems {
+			continue
+		}
+		seen[before] = true
+		notifyBefore = append(notifyBefore, before)
+	}
+	sort.Slice(notifyBefore, func(i, j int) bool { return notifyBefore[i] > notifyBefore[j] })
+	return notifyBefore
+}
@@ -1,32 +1,105 @@
 package pages
 
 import (
-	"github.com/pkg/errors"
+	"fmt"
+	"net/url"
 	"reminder/core"
 	"reminder/core/page"
+	"reminder/geotz"
 	"reminder/models"
 	"reminder/storages/chats"
-	"strconv"
+	"reminder/timezones"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	localTimeFormat = "15:04"
 )
 
 type ChangeTimezone struct {
 	*page.BasePage
 
 	Chats chats.Storage
+	Geo   *geotz.Finder
 }
 
 func (ct *ChangeTimezone) Init(builder *page.PagesBuilder) error {
 	controllers := map[string]page.Controller{
+		"main":        ct.mainController,
 		"on_timezone": ct.onTimezoneController,
+		"confirm":     ct.confirmController,
 	}
 	var err error
 	ct.BasePage, err = builder.NewBasePage("change_timezone", nil, controllers)
 	return err
 }
 
+func (ct *ChangeTimezone) mainController(req *core.Request) (map[string]interface{}, *core.URL, error) {
+	chat, err := ct.Chats.Get(req.Ctx, req.ChatID)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "chats storage get")
+	}
+	if chat == nil {
+		return map[string]interface{}{"current_timezone": "", "fixed_offset": false}, nil, nil
+	}
+	// chats migrated from the legacy hours offset keep a fixed offset until the user picks a city
+	return map[string]interface{}{
+		"current_timezone": chat.Timezone,
+		"fixed_offset":     timezones.IsFixedOffset(chat.Timezone),
+	}, nil, nil
+}
+
+// onTimezoneController accepts a zone name, a city or an offset, the "zone" param is set by the candidates buttons.
+// A shared location or the current local time only suggest a zone, it's saved after the confirmation.
+// The current local time tells only the offset, so the suggested zone is approximate, as well as the zone
+// of the nearest city for a location outside of the loaded boundaries.
 func (ct *ChangeTimezone) onTimezoneController(req *core.Request) (map[string]interface{}, *core.URL, error) {
 	ct.GetLogger(req.Ctx).Infof("on timezone input: %s", req.MsgText)
-	timezone, err := strconv.Atoi(req.MsgText)
+	if location, ok := req.Msg.(*core.LocationMessage); ok && ct.Geo != nil {
+		zone, exact := ct.Geo.Lookup(location.Latitude, location.Longitude)
+		return suggestTimezone(zone, !exact)
+	}
+	query, ok := req.URL.Params["zone"]
+	if !ok {
+		query = req.MsgText
+	}
+	names := timezones.Resolve(query)
+	if len(names) == 0 {
+		chat, err := ct.Chats.Get(req.Ctx, req.ChatID)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "chats storage get")
+		}
+		current := ""
+		if chat != nil {
+			current = chat.Timezone
+		}
+		if zone, ok := timezones.InferZone(query, time.Now(), current); ok {
+			return suggestTimezone(zone, true)
+		}
+		return page.BadInputResponse(fmt.Sprintf("cannot find a timezone for %s", query))
+	}
+	if len(names) > 1 {
+		buttons := make([]interface{}, len(names))
+		for i, name := range names {
+			buttons[i] = map[string]interface{}{"text": name, "handler": "on_timezone?zone=" + url.QueryEscape(name)}
+		}
+		return map[string]interface{}{"candidates": true, "zone_buttons": buttons}, nil, nil
+	}
+	return ct.saveTimezone(req, names[0])
+}
+
+func (ct *ChangeTimezone) confirmController(req *core.Request) (map[string]interface{}, *core.URL, error) {
+	timezone := req.URL.Params["zone"]
+	if timezone == "" {
+		return nil, nil, errors.New("'zone' not found in url params")
+	}
+	return ct.saveTimezone(req, timezone)
+}
+
+func (ct *ChangeTimezone) saveTimezone(req *core.Request, timezone string) (map[string]interface{}, *core.URL, error) {
+	loc, err := timezones.Load(timezone)
 	if err != nil {
 		return page.BadInputResponse(err.Error())
 	}
@@ -43,5 +116,19 @@ func (ct *ChangeTimezone) onTimezoneController(req *core.Request) (map[string]in
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "chats storage save")
 	}
-	return nil, nil, nil
+	return map[string]interface{}{"timezone": timezone, "local_time": time.Now().In(loc).Format(localTimeFormat)},
+		nil, nil
+}
+
+func suggestTimezone(timezone string, approximate bool) (map[string]interface{}, *core.URL, error) {
+	loc, err := timezones.Load(timezone)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "suggested timezone")
+	}
+	return map[string]interface{}{
+		"suggested":   timezone,
+		"approximate": approximate,
+		"zone_param":  url.QueryEscape(timezone),
+		"local_time":  time.Now().In(loc).Format(localTimeFormat),
+	}, nil, nil
 }
@@ -0,0 +1,35 @@
+package pages
+
+import (
+	"fmt"
+	"reminder/core"
+	"reminder/core/page"
+	"strings"
+)
+
+type Help struct {
+	*page.BasePage
+	// Commands returns the commands published in the messenger menu, so the help lists the same ones.
+	Commands func() []*page.BotCommand
+}
+
+func (h *Help) Init(builder *page.PagesBuilder) error {
+	controllers := map[string]page.Controller{
+		"help": h.helpController,
+	}
+	var err error
+	h.BasePage, err = builder.NewBasePage("help", nil, controllers)
+	return err
+}
+
+func (h *Help) helpController(req *core.Request) (map[string]interface{}, *core.URL, error) {
+	var commands []*page.BotCommand
+	if h.Commands != nil {
+		commands = h.Commands()
+	}
+	lines := make([]string, len(commands))
+	for i, command := range commands {
+		lines[i] = fmt.Sprintf("/%s - %s", command.Name, command.Description)
+	}
+	return map[string]interface{}{"commands": strings.Join(lines, "\n")}, nil, nil
+}
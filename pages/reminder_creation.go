@@ -1,23 +1,38 @@
 package pages
 
 import (
-	"fmt"
 	"github.com/gazoon/bot_libs/messenger"
 	"github.com/gazoon/bot_libs/utils"
-	"github.com/mitchellh/mapstructure"
 	"github.com/pkg/errors"
 	"reminder/core"
 	"reminder/core/page"
+	"reminder/dateparse"
 	"reminder/models"
+	"reminder/recurrence"
 	"reminder/storages/chats"
 	"reminder/storages/reminders"
+	"strings"
 	"time"
+	"unicode"
 )
 
-var (
-	timeFormats = []string{"2006-01-02 15:04:05", "2006.01.02 15:04:05"}
+const (
+	noRecurrence = "none"
+	// selfMention assigns the reminder to its creator
+	selfMention = "me"
+	// quickSeparator splits the time and the title of a quick reminder, e.g "tomorrow 10:00 to deploy"
+	quickSeparator = " to "
+	// confirmationTimeFormat shows the interpreted remind time, so users can spot a misunderstood date
+	confirmationTimeFormat = "Mon, 02 Jan 2006 15:04"
 )
 
+// recurrenceKeywords are typed alternatives of the repetition buttons, other input is parsed as a rule.
+var recurrenceKeywords = map[string]string{
+	"no": noRecurrence, "none": noRecurrence, "once": noRecurrence, "never": noRecurrence,
+	"don't repeat": noRecurrence, "daily": "FREQ=DAILY", "weekly": "FREQ=WEEKLY", "monthly": "FREQ=MONTHLY",
+	"yearly": "FREQ=YEARLY",
+}
+
 type ReminderCreation struct {
 	*page.BasePage
 
@@ -31,18 +46,40 @@ func (rc *ReminderCreation) Init(builder *page.PagesBuilder) error {
 	controllers := map[string]page.Controller{
 		"on_title":       rc.onTitleController,
 		"on_date":        rc.onDateController,
+		"on_recurrence":  rc.onRecurrenceController,
 		"on_description": rc.onDescriptionController,
 		"done":           rc.doneController,
+		"quick":          rc.quickController,
 	}
 	rc.BasePage, err = builder.NewBasePage("reminder_creation", nil, controllers)
 	return err
 }
 
+func (rc *ReminderCreation) setFormField(req *core.Request, key string, value interface{}) error {
+	err := rc.SetStateAs(req, key, value)
+	if err != nil {
+		return err
+	}
+	return rc.SetStateAs(req, "last_enter", key)
+}
+
+// onTitleController takes #tags and the @username of the assignee from the title.
 func (rc *ReminderCreation) onTitleController(req *core.Request) (map[string]interface{}, *core.URL, error) {
-	title := req.MsgText
-	rc.UpdateState(req, "title", title)
-	rc.UpdateState(req, "last_enter", "title")
-	return nil, nil, nil
+	text, assignee := extractAssignee(req, req.MsgText)
+	title, tags, err := parseTitle(text)
+	if err != nil {
+		return page.BadInputResponse(err.Error())
+	}
+	err = rc.SetStateAs(req, "tags", tags)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = rc.SetStateAs(req, "assignee", assignee)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = rc.setFormField(req, "title", title)
+	return nil, nil, err
 }
 
 func (rc *ReminderCreation) onDateController(req *core.Request) (map[string]interface{}, *core.URL, error) {
@@ -53,34 +90,47 @@ func (rc *ReminderCreation) onDateController(req *core.Request) (map[string]inte
 	if chat == nil {
 		return map[string]interface{}{"no_timezone": true}, nil, nil
 	}
-	var remindAt time.Time
-	for _, format := range timeFormats {
-		var err error
-		remindAt, err = time.Parse(format, req.MsgText)
-		if err == nil {
-			break
+	remindAtUTC, err := parseRemindAt(req.MsgText, chat)
+	if err != nil {
+		return page.BadInputResponse(err.Error())
+	}
+	err = rc.SetStateAs(req, "remind_at", remindAtUTC)
+	if err != nil {
+		return nil, nil, err
+	}
+	err = rc.SetStateAs(req, "last_enter", "date")
+	return remindAtConfirmation(remindAtUTC, chat), nil, err
+}
+
+func (rc *ReminderCreation) onRecurrenceController(req *core.Request) (map[string]interface{}, *core.URL, error) {
+	ruleStr, ok := req.URL.Params["rule"]
+	if !ok {
+		ruleStr = req.MsgText
+		if keywordRule, ok := recurrenceKeywords[strings.ToLower(strings.TrimSpace(ruleStr))]; ok {
+			ruleStr = keywordRule
 		}
 	}
-	if remindAt.IsZero() {
-		return page.BadInputResponse(fmt.Sprintf("cannot parse in any of these formats: %v", timeFormats))
+	if ruleStr == noRecurrence {
+		err := rc.setFormField(req, "recurrence", "")
+		return nil, nil, err
 	}
-	remindAtUTC := chat.ToUTC(remindAt)
-	rc.UpdateState(req, "remind_at", remindAtUTC)
-	rc.UpdateState(req, "last_enter", "date")
-	return nil, nil, nil
+	rule, err := recurrence.Parse(ruleStr)
+	if err != nil {
+		return page.BadInputResponse(err.Error())
+	}
+	err = rc.setFormField(req, "recurrence", rule.String())
+	return nil, nil, err
 }
 
 func (rc *ReminderCreation) onDescriptionController(req *core.Request) (map[string]interface{}, *core.URL, error) {
-	description := req.MsgText
-	rc.UpdateState(req, "description", description)
-	rc.UpdateState(req, "last_enter", "description")
-	return nil, nil, nil
+	description := parseDescription(req.MsgText)
+	err := rc.setFormField(req, "description", description)
+	return nil, nil, err
 }
 
 func (rc *ReminderCreation) doneController(req *core.Request) (map[string]interface{}, *core.URL, error) {
-	data := rc.GetState(req)
 	form := &ReminderForm{}
-	err := mapstructure.Decode(data, form)
+	err := rc.DecodeState(req, form)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "to form decode")
 	}
@@ -89,6 +139,15 @@ func (rc *ReminderCreation) doneController(req *core.Request) (map[string]interf
 		return nil, nil, errors.Wrap(err, "form validation")
 	}
 	reminder := models.NewReminder(req.ChatID, form.Title, form.RemindAt, form.Description)
+	reminder.AddTags(form.Tags...)
+	reminder.CreatorID = req.UserID
+	assign(reminder, form.Assignee, req)
+	if form.Recurrence != "" {
+		reminder.Recurrence, err = recurrence.Parse(form.Recurrence)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "form recurrence")
+		}
+	}
 	err = rc.Reminders.Save(req.Ctx, reminder)
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "reminders storage save ")
@@ -102,8 +161,123 @@ func (rc *ReminderCreation) doneController(req *core.Request) (map[string]interf
 	return nil, nil, nil
 }
 
+// quickController creates a reminder from a single message like "/remind @alice tomorrow 10:00 to deploy",
+// the assignee is optional.
+func (rc *ReminderCreation) quickController(req *core.Request) (map[string]interface{}, *core.URL, error) {
+	text := strings.TrimSpace(req.URL.Params[core.CommandArgsParam])
+	if text == "" {
+		return map[string]interface{}{"no_args": true}, nil, nil
+	}
+	chat, err := rc.Chats.Get(req.Ctx, req.ChatID)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "chats get failed")
+	}
+	if chat == nil {
+		return map[string]interface{}{"no_timezone": true}, nil, nil
+	}
+	var assignee string
+	if strings.HasPrefix(text, "@") {
+		text, assignee = extractAssignee(req, text)
+	}
+	separator := strings.Index(strings.ToLower(text), quickSeparator)
+	if separator == -1 {
+		return page.BadInputResponse("expected the time and the title separated by 'to'")
+	}
+	remindAt, err := parseRemindAt(text[:separator], chat)
+	if err != nil {
+		return page.BadInputResponse(err.Error())
+	}
+	title, tags, err := parseTitle(text[separator+len(quickSeparator):])
+	if err != nil {
+		return page.BadInputResponse(err.Error())
+	}
+	reminder := models.NewReminder(req.ChatID, title, remindAt, nil)
+	reminder.AddTags(tags...)
+	reminder.CreatorID = req.UserID
+	assign(reminder, assignee, req)
+	err = rc.Reminders.Save(req.Ctx, reminder)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "reminders storage save")
+	}
+	data := remindAtConfirmation(remindAt, chat)
+	data["reminder_id"], data["title"], data["assignee"] = reminder.ID, reminder.Title, reminder.Mention()
+	return data, nil, nil
+}
+
+// assign sets the assignee by the mentioned username, "me" or the own username assign the sender.
+func assign(reminder *models.Reminder, username string, req *core.Request) {
+	switch {
+	case username == "":
+	case username == selfMention || strings.EqualFold(username, req.Username):
+		reminder.Assign(req.UserID, req.Username)
+	default:
+		reminder.Assign(0, username)
+	}
+}
+
+// extractAssignee takes the @username of the assignee from the text. Only group chats have members
+// to assign to, a mention in a private chat is a part of the text.
+func extractAssignee(req *core.Request, text string) (string, string) {
+	if !req.IsGroup {
+		return text, ""
+	}
+	return models.ExtractMention(text)
+}
+
+// parseTitle, parseRemindAt and parseDescription validate user input for both creation and editing.
+// parseTitle extracts #tags from the title.
+func parseTitle(text string) (string, []string, error) {
+	title, tags := models.ExtractTags(strings.TrimSpace(text))
+	if title == "" {
+		return "", nil, errors.New("title can't be empty")
+	}
+	return title, tags, nil
+}
+
+// parseTags accepts tags with or without #, separated by spaces or commas.
+func parseTags(text string) ([]string, error) {
+	var tags []string
+	for _, field := range strings.FieldsFunc(text, func(r rune) bool { return r == ',' || unicode.IsSpace(r) }) {
+		tag, ok := models.NormalizeTag(field)
+		if !ok {
+			return nil, errors.Errorf("'%s' isn't a valid tag, use letters, digits, '_' and '-'", strings.TrimPrefix(field, "#"))
+		}
+		tags = append(tags, tag)
+	}
+	if len(tags) == 0 {
+		return nil, errors.New("no tags found")
+	}
+	return tags, nil
+}
+
+func parseRemindAt(text string, chat *models.Chat) (time.Time, error) {
+	remindAt, err := dateparse.Parse(text, time.Now(), chat.Location())
+	if err != nil {
+		return time.Time{}, err
+	}
+	if !remindAt.After(time.Now()) {
+		return time.Time{}, errors.New("the time is in the past")
+	}
+	return remindAt, nil
+}
+
+func remindAtConfirmation(remindAt time.Time, chat *models.Chat) map[string]interface{} {
+	return map[string]interface{}{"remind_at": remindAt.In(chat.Location()).Format(confirmationTimeFormat)}
+}
+
+func parseDescription(text string) *string {
+	description := strings.TrimSpace(text)
+	if description == "" {
+		return nil
+	}
+	return &description
+}
+
 type ReminderForm struct {
-	Title       string    `mapstructure:"title" validate:"required"`
-	RemindAt    time.Time `mapstructure:"remind_at" validate:"required"`
-	Description *string   `mapstructure:"description"`
+	Title       string    `json:"title" validate:"required"`
+	RemindAt    time.Time `json:"remind_at" validate:"required"`
+	Description *string   `json:"description"`
+	Recurrence  string    `json:"recurrence"`
+	Tags        []string  `json:"tags"`
+	Assignee    string    `json:"assignee"`
 }
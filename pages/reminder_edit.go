@@ -0,0 +1,271 @@
+package pages
+
+import (
+	"reminder/core"
+	"reminder/core/page"
+	"reminder/models"
+	"reminder/storages/chats"
+	"reminder/storages/reminders"
+
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	minNagMinutes        = 1
+	maxNotifyBefore      = 30 * 24 * time.Hour
+	maxNotifyBeforeItems = 5
+)
+
+var (
+	notifyBeforeItemRegexp = regexp.MustCompile(`(\d+)\s*(m|min|mins|minutes?|h|hours?|d|days?)\b`)
+	notifyBeforeSeparators = strings.NewReplacer(",", "", "and", "", "before", "")
+	notifyBeforeUnits      = map[string]time.Duration{"m": time.Minute, "h": time.Hour, "d": 24 * time.Hour}
+)
+
+type ReminderEdit struct {
+	*page.BasePage
+
+	Reminders reminders.Storage
+	Chats     chats.Storage
+}
+
+func (re *ReminderEdit) Init(builder *page.PagesBuilder) error {
+	var err error
+	controllers := map[string]page.Controller{
+		"show":           re.showController,
+		"on_title":       re.onTitleController,
+		"on_date":        re.onDateController,
+		"on_description": re.onDescriptionController,
+		"on_nag":         re.onNagController,
+		"on_notify":      re.onNotifyController,
+		"on_tags":        re.onTagsController,
+		"on_assignee":    re.onAssigneeController,
+	}
+	re.BasePage, err = builder.NewBasePage("reminder_edit", nil, controllers)
+	return err
+}
+
+func (re *ReminderEdit) showController(req *core.Request) (map[string]interface{}, *core.URL, error) {
+	reminder, err := re.getReminder(req)
+	if err != nil || reminder == nil {
+		return reminderNotFoundData(), nil, err
+	}
+	return map[string]interface{}{"reminder_id": reminder.ID, "title": reminder.Title}, nil, nil
+}
+
+func (re *ReminderEdit) onTitleController(req *core.Request) (map[string]interface{}, *core.URL, error) {
+	text, assignee := extractAssignee(req, req.MsgText)
+	title, tags, err := parseTitle(text)
+	if err != nil {
+		return page.BadInputResponse(err.Error())
+	}
+	return re.update(req, nil, func(reminder *models.Reminder) {
+		reminder.Title = title
+		reminder.AddTags(tags...)
+		assign(reminder, assignee, req)
+	})
+}
+
+func (re *ReminderEdit) onDateController(req *core.Request) (map[string]interface{}, *core.URL, error) {
+	chat, err := re.Chats.Get(req.Ctx, req.ChatID)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "chats storage get")
+	}
+	if chat == nil {
+		return map[string]interface{}{"no_timezone": true}, nil, nil
+	}
+	remindAt, err := parseRemindAt(req.MsgText, chat)
+	if err != nil {
+		return page.BadInputResponse(err.Error())
+	}
+	return re.update(req, remindAtConfirmation(remindAt, chat), func(reminder *models.Reminder) {
+		// a fired reminder waiting for acknowledgement is rescheduled, it doesn't nag until the new time
+		reminder.AckPending, reminder.OccurrenceAt, reminder.NagRepeats = false, nil, 0
+		reminder.RemindAt = remindAt
+		if reminder.Recurrence != nil {
+			// the next occurrences take the time of day of the new remind time
+			reminder.Recurrence.TimeOfDay = nil
+		}
+		reminder.Reschedule()
+	})
+}
+
+func (re *ReminderEdit) onDescriptionController(req *core.Request) (map[string]interface{}, *core.URL, error) {
+	description := parseDescription(req.MsgText)
+	if req.URL.Params["clear"] == "true" {
+		description = nil
+	}
+	return re.update(req, nil, func(reminder *models.Reminder) {
+		reminder.Description = description
+	})
+}
+
+// onNagController accepts "off" or the interval in minutes, optionally followed by the max number of repeats.
+func (re *ReminderEdit) onNagController(req *core.Request) (map[string]interface{}, *core.URL, error) {
+	text := req.MsgText
+	if minutes, ok := req.URL.Params["minutes"]; ok {
+		text = minutes
+	}
+	interval, maxRepeats, err := parseNag(text)
+	if err != nil {
+		return page.BadInputResponse(err.Error())
+	}
+	reminder, err := re.getReminder(req)
+	if err != nil || reminder == nil {
+		return reminderNotFoundData(), nil, err
+	}
+	reminder.NagInterval, reminder.NagMaxRepeats = interval, maxRepeats
+	if interval != 0 || !reminder.AckPending {
+		err = re.Reminders.Save(req.Ctx, reminder)
+		return nil, nil, errors.Wrap(err, "reminders storage save")
+	}
+	// the occurrence has fired and won't be repeated anymore, the remind time is the occurrence time again
+	reminder.Acknowledge()
+	err = finishOccurrence(req, re.Reminders, re.Chats, reminder, models.ArchivedFired)
+	return nil, nil, err
+}
+
+// onNotifyController accepts "off" or advance notification times like "1d 15m".
+func (re *ReminderEdit) onNotifyController(req *core.Request) (map[string]interface{}, *core.URL, error) {
+	text := req.MsgText
+	if before, ok := req.URL.Params["before"]; ok {
+		text = before
+	}
+	notifyBefore, err := parseNotifyBefore(text)
+	if err != nil {
+		return page.BadInputResponse(err.Error())
+	}
+	return re.update(req, nil, func(reminder *models.Reminder) {
+		reminder.NotifyBefore = notifyBefore
+	})
+}
+
+// onTagsController replaces tags of the reminder, the clear=true param removes all of them.
+func (re *ReminderEdit) onTagsController(req *core.Request) (map[string]interface{}, *core.URL, error) {
+	var tags []string
+	if req.URL.Params["clear"] != "true" {
+		var err error
+		tags, err = parseTags(req.MsgText)
+		if err != nil {
+			return page.BadInputResponse(err.Error())
+		}
+	}
+	return re.update(req, nil, func(reminder *models.Reminder) {
+		reminder.Tags = nil
+		reminder.AddTags(tags...)
+	})
+}
+
+// onAssigneeController accepts a username with or without @ or "me", the clear=true param unassigns the reminder.
+func (re *ReminderEdit) onAssigneeController(req *core.Request) (map[string]interface{}, *core.URL, error) {
+	if req.URL.Params["clear"] == "true" {
+		return re.update(req, nil, func(reminder *models.Reminder) {
+			reminder.Assign(0, "")
+		})
+	}
+	text := req.MsgText
+	if username, ok := req.URL.Params["username"]; ok {
+		text = username
+	}
+	username, ok := models.NormalizeUsername(text)
+	if !ok {
+		return page.BadInputResponse("expected a username like @alice")
+	}
+	return re.update(req, nil, func(reminder *models.Reminder) {
+		assign(reminder, username, req)
+	})
+}
+
+// update applies the change to the stored reminder, saves it under the same id and responds with the data.
+func (re *ReminderEdit) update(req *core.Request, data map[string]interface{},
+	change func(reminder *models.Reminder)) (map[string]interface{}, *core.URL, error) {
+
+	reminder, err := re.getReminder(req)
+	if err != nil || reminder == nil {
+		return reminderNotFoundData(), nil, err
+	}
+	change(reminder)
+	err = re.Reminders.Save(req.Ctx, reminder)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "reminders storage save")
+	}
+	return data, nil, nil
+}
+
+// getReminder returns nil if there is no such active reminder in the chat, archived ones can't be edited.
+func (re *ReminderEdit) getReminder(req *core.Request) (*models.Reminder, error) {
+	reminderID := req.URL.Params["reminder_id"]
+	if reminderID == "" {
+		return nil, errors.New("'reminder_id' not found in url params")
+	}
+	reminder, err := re.Reminders.Get(req.Ctx, reminderID)
+	if err != nil {
+		return nil, errors.Wrap(err, "reminders storage get")
+	}
+	if reminder == nil || reminder.ChatID != req.ChatID || reminder.IsArchived() {
+		return nil, nil
+	}
+	return reminder, nil
+}
+
+func parseNag(text string) (time.Duration, int, error) {
+	fields := strings.Fields(strings.ToLower(text))
+	if len(fields) == 1 && (fields[0] == "off" || fields[0] == "0") {
+		return 0, 0, nil
+	}
+	if len(fields) == 0 || len(fields) > 2 {
+		return 0, 0, errors.New("expected minutes and optionally the max number of repeats")
+	}
+	minutes, err := strconv.Atoi(fields[0])
+	if err != nil || minutes < minNagMinutes {
+		return 0, 0, errors.Errorf("interval must be at least %d minute", minNagMinutes)
+	}
+	var maxRepeats int
+	if len(fields) == 2 {
+		maxRepeats, err = strconv.Atoi(fields[1])
+		if err != nil || maxRepeats < 1 {
+			return 0, 0, errors.New("max repeats must be a positive number")
+		}
+	}
+	return time.Duration(minutes) * time.Minute, maxRepeats, nil
+}
+
+func parseNotifyBefore(text string) ([]time.Duration, error) {
+	text = strings.ToLower(strings.TrimSpace(text))
+	if text == "off" || text == "none" {
+		return nil, nil
+	}
+	matches := notifyBeforeItemRegexp.FindAllStringSubmatch(text, -1)
+	rest := notifyBeforeSeparators.Replace(notifyBeforeItemRegexp.ReplaceAllString(text, ""))
+	if len(matches) == 0 || strings.TrimSpace(rest) != "" {
+		return nil, errors.New("expected times like '15m', '2h' or '1d'")
+	}
+	var notifyBefore []time.Duration
+	seen := make(map[time.Duration]bool, len(matches))
+	for _, match := range matches {
+		n, _ := strconv.Atoi(match[1])
+		before := time.Duration(n) * notifyBeforeUnits[match[2][:1]]
+		if before <= 0 || before > maxNotifyBefore {
+			return nil, errors.Errorf("%s is not between a minute and %d days", match[0], maxNotifyBefore/(24*time.Hour))
+		}
+		if !seen[before] {
+			seen[before] = true
+			notifyBefore = append(notifyBefore, before)
+		}
+	}
+	if len(notifyBefore) > maxNotifyBeforeItems {
+		return nil, errors.Errorf("at most %d advance notifications are allowed", maxNotifyBeforeItems)
+	}
+	sort.Slice(notifyBefore, func(i, j int) bool { return notifyBefore[i] > notifyBefore[j] })
+	return notifyBefore, nil
+}
+
+func reminderNotFoundData() map[string]interface{} {
+	return map[string]interface{}{"reminder_not_found": true}
+}
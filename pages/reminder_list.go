@@ -5,7 +5,9 @@ import (
 	"reminder/core/page"
 
 	"fmt"
+	"net/url"
 	"reminder/models"
+	"reminder/storages/chats"
 	"reminder/storages/reminders"
 	"strconv"
 	"strings"
@@ -13,26 +15,67 @@ import (
 	"github.com/pkg/errors"
 )
 
+const (
+	maxArchivedReminders = 20
+	maxTagButtons        = 5
+)
+
 type ReminderList struct {
 	*page.BasePage
 
 	Reminders reminders.Storage
+	Chats     chats.Storage
 }
 
 func (rl *ReminderList) Init(builder *page.PagesBuilder) error {
 	controllers := map[string]page.Controller{
 		"on_get_or_delete": rl.getOrDeleteInputController,
+		"undo":             rl.undoController,
+		"archive":          rl.archiveController,
 	}
 	var err error
 	rl.BasePage, err = builder.NewBasePage("reminder_list", rl.globalController, controllers)
 	return err
 }
 
+// getReminders lists reminders with the tag from the "tag" url param, the "mine" param keeps reminders
+// of the list owner, so numbers typed by the user refer to the same list they see.
 func (rl *ReminderList) getReminders(req *core.Request) ([]*models.Reminder, error) {
-	list, err := rl.Reminders.List(req.Ctx, req.ChatID)
+	filter := reminders.ListFilter{Tag: req.URL.Params["tag"]}
+	if owner := listOwner(req); owner != 0 {
+		filter.UserID = owner
+		if owner == req.UserID {
+			filter.Username = req.Username
+		}
+	}
+	list, err := rl.Reminders.List(req.Ctx, req.ChatID, filter)
 	return list, errors.Wrap(err, "storage list")
 }
 
+// listOwner returns the id of the user whose reminders are listed, it's zero for reminders of the whole chat.
+// The "mine" url param is "true" for the list of the requesting user, e.g in buttons shared by the group,
+// the input handler keeps the id of the user who got the list.
+func listOwner(req *core.Request) int {
+	mine := req.URL.Params["mine"]
+	if mine == "true" {
+		return req.UserID
+	}
+	owner, _ := strconv.Atoi(mine)
+	return owner
+}
+
+// listFilterQuery encodes the list filter for urls of the list actions, mine is "true", a user id or empty.
+func listFilterQuery(tag, mine string) string {
+	values := url.Values{}
+	if tag != "" {
+		values.Set("tag", tag)
+	}
+	if mine != "" {
+		values.Set("mine", mine)
+	}
+	return values.Encode()
+}
+
 func parseGetOrDelete(req *core.Request) (string, int, error) {
 	parts := strings.Split(req.MsgText, " ")
 	if len(parts) != 2 {
@@ -54,6 +97,17 @@ func parseGetOrDelete(req *core.Request) (string, int, error) {
 }
 
 func (rl *ReminderList) getOrDeleteInputController(req *core.Request) (map[string]interface{}, *core.URL, error) {
+	owner := listOwner(req)
+	if owner != 0 && owner != req.UserID {
+		return page.BadInputResponse("this list belongs to another member, open your own list")
+	}
+	if strings.HasPrefix(strings.TrimSpace(req.MsgText), "#") {
+		tag, ok := models.NormalizeTag(req.MsgText)
+		if !ok {
+			return page.BadInputResponse("invalid tag")
+		}
+		return map[string]interface{}{"filter_tag": listFilterQuery(tag, ownerParam(owner))}, nil, nil
+	}
 	command, index, err := parseGetOrDelete(req)
 	if err != nil {
 		return page.BadInputResponse(err.Error())
@@ -75,7 +129,52 @@ func (rl *ReminderList) getOrDeleteInputController(req *core.Request) (map[strin
 		isDeleted = true
 	}
 
-	return map[string]interface{}{"deleted": isDeleted, "reminder_id": reminder.ID}, nil, nil
+	return map[string]interface{}{"deleted": isDeleted, "reminder_id": reminder.ID, "title": reminder.Title}, nil, nil
+}
+
+// undoController restores the reminder deleted from the list.
+func (rl *ReminderList) undoController(req *core.Request) (map[string]interface{}, *core.URL, error) {
+	reminderID := req.URL.Params["reminder_id"]
+	if reminderID == "" {
+		return nil, nil, errors.New("'reminder_id' not found in url params")
+	}
+	reminder, err := rl.Reminders.Get(req.Ctx, reminderID)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "reminders storage get")
+	}
+	if reminder == nil || reminder.ChatID != req.ChatID || reminder.ArchivedAs != models.ArchivedDeleted {
+		return map[string]interface{}{"undo_failed": true}, nil, nil
+	}
+	reminder.Restore()
+	err = rl.Reminders.Save(req.Ctx, reminder)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "reminders storage save")
+	}
+	return map[string]interface{}{"title": reminder.Title}, nil, nil
+}
+
+func (rl *ReminderList) archiveController(req *core.Request) (map[string]interface{}, *core.URL, error) {
+	archived, err := rl.Reminders.ListArchived(req.Ctx, req.ChatID, maxArchivedReminders)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "storage list archived")
+	}
+	chat, err := rl.Chats.Get(req.Ctx, req.ChatID)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "chats storage get")
+	}
+	if chat == nil {
+		chat = models.NewChat(req.ChatID, models.DefaultTimezone)
+	}
+	previews := make([]interface{}, len(archived))
+	for i, reminder := range archived {
+		previews[i] = fmt.Sprintf("%d. %.30s (%s %s)", i+1, reminder.Title, reminder.ArchivedAs,
+			chat.ToLocalTime(*reminder.ArchivedAt).Format(confirmationTimeFormat))
+	}
+	data := map[string]interface{}{
+		"no_archived":       len(archived) == 0,
+		"archived_previews": previews,
+	}
+	return data, nil, nil
 }
 
 func (rl *ReminderList) globalController(req *core.Request) (map[string]interface{}, *core.URL, error) {
@@ -84,12 +183,67 @@ func (rl *ReminderList) globalController(req *core.Request) (map[string]interfac
 		return nil, nil, err
 	}
 	previews := make([]interface{}, len(chatReminders))
+	tagButtons := []interface{}{}
+	seenTags := map[string]bool{}
+	owner := listOwner(req)
+	mine := owner != 0
+	// buttons are shared by the group, their lists are of the user who presses them
+	buttonsMine := ""
+	if mine {
+		buttonsMine = "true"
+	}
 	for i, reminder := range chatReminders {
-		previews[i] = fmt.Sprintf("%d. %.30s", i+1, reminder.Title)
+		preview := fmt.Sprintf("%d. %.30s", i+1, reminder.Title) + formatTags(reminder.Tags, " ")
+		if mention := reminder.Mention(); mention != "" && !mine {
+			preview += " → " + mention
+		}
+		if reminder.Failed {
+			preview += " (failed to send)"
+		}
+		previews[i] = preview
+		for _, tag := range reminder.Tags {
+			if !seenTags[tag] && len(tagButtons) < maxTagButtons {
+				seenTags[tag] = true
+				tagButtons = append(tagButtons, map[string]interface{}{"text": "#" + tag,
+					"handler": "reminders?" + listFilterQuery(tag, buttonsMine)})
+			}
+		}
+	}
+	tag := req.URL.Params["tag"]
+	tagSuffix := ""
+	if tag != "" {
+		tagButtons = []interface{}{map[string]interface{}{"text": "All tags",
+			"handler": "reminders?" + listFilterQuery("", buttonsMine), "intents": []interface{}{"all"}}}
+		tagSuffix = " tagged #" + tag
 	}
 	data := map[string]interface{}{
 		"no_reminders":      len(chatReminders) == 0,
 		"reminder_previews": previews,
+		"tag":               tag,
+		"tag_suffix":        tagSuffix,
+		"mine":              mine,
+		"filtered":          tag != "" || mine,
+		"filter":            listFilterQuery(tag, ownerParam(owner)),
+		"show_mine":         req.IsGroup && !mine && req.UserID != 0,
+		"mine_filter":       listFilterQuery(tag, "true"),
+		"everyones_filter":  listFilterQuery(tag, ""),
+		"has_tag_buttons":   len(tagButtons) != 0,
+		"tag_buttons":       tagButtons,
 	}
 	return data, nil, nil
 }
+
+func ownerParam(owner int) string {
+	if owner == 0 {
+		return ""
+	}
+	return strconv.Itoa(owner)
+}
+
+// formatTags returns tags like "#work #home" with the prefix, it's empty if there are no tags.
+func formatTags(tags []string, prefix string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+	return prefix + "#" + strings.Join(tags, " #")
+}
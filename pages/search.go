@@ -0,0 +1,90 @@
+package pages
+
+import (
+	"fmt"
+	"reminder/core"
+	"reminder/core/page"
+	"reminder/storages/reminders"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	maxSearchResults       = 10
+	maxSearchQueryLength   = 200
+	searchPreviewMaxLength = 100
+)
+
+type Search struct {
+	*page.BasePage
+
+	Reminders reminders.Storage
+}
+
+func (s *Search) Init(builder *page.PagesBuilder) error {
+	var err error
+	controllers := map[string]page.Controller{
+		"on_query": s.onQueryController,
+		"delete":   s.deleteController,
+	}
+	s.BasePage, err = builder.NewBasePage("search", nil, controllers)
+	return err
+}
+
+// onQueryController responds with a message per found reminder, each one has its own show and delete buttons.
+func (s *Search) onQueryController(req *core.Request) (map[string]interface{}, *core.URL, error) {
+	query := strings.TrimSpace(req.MsgText)
+	if query == "" {
+		return page.BadInputResponse("query can't be empty")
+	}
+	if len(query) > maxSearchQueryLength {
+		return page.BadInputResponse(fmt.Sprintf("query must be shorter than %d characters", maxSearchQueryLength))
+	}
+	found, err := s.Reminders.Search(req.Ctx, req.ChatID, query, maxSearchResults)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "reminders storage search")
+	}
+	results := make([]interface{}, len(found))
+	for i, reminder := range found {
+		text := fmt.Sprintf("%d. %s", i+1, reminder.Title) + formatTags(reminder.Tags, " ")
+		if reminder.Description != nil {
+			text += "\n" + truncate(*reminder.Description, searchPreviewMaxLength)
+		}
+		results[i] = map[string]interface{}{
+			"text": text,
+			"buttons": []interface{}{
+				map[string]interface{}{"text": "Show", "handler": "page://show_reminder?reminder_id=" + reminder.ID},
+				map[string]interface{}{"text": "Delete", "handler": "delete?reminder_id=" + reminder.ID},
+			},
+		}
+	}
+	return map[string]interface{}{"query": query, "results": results, "no_results": len(found) == 0}, nil, nil
+}
+
+func (s *Search) deleteController(req *core.Request) (map[string]interface{}, *core.URL, error) {
+	reminderID := req.URL.Params["reminder_id"]
+	if reminderID == "" {
+		return nil, nil, errors.New("'reminder_id' not found in url params")
+	}
+	reminder, err := s.Reminders.Get(req.Ctx, reminderID)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "reminders storage get")
+	}
+	if reminder == nil || reminder.ChatID != req.ChatID || reminder.IsArchived() {
+		return map[string]interface{}{"reminder_not_found": true}, nil, nil
+	}
+	err = s.Reminders.Delete(req.Ctx, reminder.ID)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "reminders storage delete")
+	}
+	return map[string]interface{}{"reminder_id": reminder.ID, "title": reminder.Title}, nil, nil
+}
+
+func truncate(text string, maxLength int) string {
+	runes := []rune(text)
+	if len(runes) <= maxLength {
+		return text
+	}
+	return string(runes[:maxLength]) + "…"
+}
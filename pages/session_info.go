@@ -0,0 +1,43 @@
+package pages
+
+import (
+	"reminder/core"
+	"reminder/core/page"
+	"strings"
+	"time"
+)
+
+// SessionInfo is an admin page showing the session of the chat, e.g to debug a stuck dialog.
+type SessionInfo struct {
+	*page.BasePage
+}
+
+func (si *SessionInfo) Init(builder *page.PagesBuilder) error {
+	controllers := map[string]page.Controller{
+		"session_info": si.sessionInfoController,
+	}
+	var err error
+	si.BasePage, err = builder.NewBasePage("session_info", nil, controllers)
+	return err
+}
+
+func (si *SessionInfo) sessionInfoController(req *core.Request) (map[string]interface{}, *core.URL, error) {
+	session := req.Session
+	lastPage := "none"
+	if session.LastPage != nil {
+		lastPage = session.LastPage.Encode()
+	}
+	history := make([]string, len(session.History))
+	for i, screen := range session.History {
+		history[i] = screen.Encode()
+	}
+	data := map[string]interface{}{
+		"chat_id":       session.ChatID,
+		"session_id":    session.ID,
+		"version":       session.Version,
+		"last_activity": session.LastActivity.Format(time.RFC3339),
+		"last_page":     lastPage,
+		"history":       strings.Join(history, " > "),
+	}
+	return data, nil, nil
+}
@@ -1,18 +1,45 @@
 package pages
 
 import (
+	"context"
+	"fmt"
 	"reminder/core"
 	"reminder/core/page"
 	"reminder/storages/chats"
 	"reminder/storages/reminders"
+	"strings"
+	"time"
 
 	"reminder/models"
 
 	"github.com/pkg/errors"
 )
 
+const (
+	firedRemindersKey = "fired_reminders"
+	maxFiredReminders = 20
+	snoozedTimeFormat = "2006-01-02 15:04"
+)
+
+var snoozeDelays = map[string]time.Duration{
+	"10m": 10 * time.Minute,
+	"1h":  time.Hour,
+}
+
+// ReminderReadyMessage.Ahead is set for advance notifications, it's how long before the remind time they are.
 type ReminderReadyMessage struct {
 	Reminder *models.Reminder
+	Ahead    time.Duration
+}
+
+// MessageEditor edits already sent messages, the messenger doesn't support it.
+type MessageEditor interface {
+	EditMessageText(ctx context.Context, chatID, msgID int, text string) error
+}
+
+// firedReminder marks the fired reminder that can be snoozed, the reminder itself is loaded from the storage.
+type firedReminder struct {
+	ID string `json:"id"`
 }
 
 type ShowReminder struct {
@@ -20,6 +47,7 @@ type ShowReminder struct {
 
 	Reminders reminders.Storage
 	Chats     chats.Storage
+	Editor    MessageEditor
 }
 
 func (sr *ShowReminder) Init(builder *page.PagesBuilder) error {
@@ -27,6 +55,9 @@ func (sr *ShowReminder) Init(builder *page.PagesBuilder) error {
 	controllers := map[string]page.Controller{
 		"show":       sr.showController,
 		"when_ready": sr.whenReadyController,
+		"snooze":     sr.snoozeController,
+		"done":       sr.doneController,
+		"retry":      sr.retryController,
 	}
 	sr.BasePage, err = builder.NewBasePage("show_reminder", nil, controllers)
 	return err
@@ -41,7 +72,213 @@ func (sr *ShowReminder) whenReadyController(req *core.Request) (map[string]inter
 	if err != nil {
 		return nil, nil, errors.Wrap(err, "chats storage get")
 	}
-	return reminderToData(msg.Reminder, chat), nil, nil
+	if msg.Ahead > 0 {
+		// the reminder isn't fired yet, so there is nothing to snooze or acknowledge
+		data := reminderToData(msg.Reminder, chat)
+		data["ahead"] = describeDuration(msg.Ahead)
+		return data, nil, nil
+	}
+	err = sr.storeFiredReminder(req, msg.Reminder)
+	if err != nil {
+		return nil, nil, err
+	}
+	data := reminderToData(msg.Reminder, chat)
+	data["msg_ids_key"] = firedMsgIDsKey(msg.Reminder.ID)
+	return data, nil, nil
+}
+
+// snoozeController reschedules the fired reminder relative to now and puts the new time to the fired message.
+// A recurring reminder keeps its schedule, so a one-off copy of it is created instead. Deleted reminders
+// can't be snoozed.
+func (sr *ShowReminder) snoozeController(req *core.Request) (map[string]interface{}, *core.URL, error) {
+	reminderID := req.URL.Params["reminder_id"]
+	if reminderID == "" {
+		return nil, nil, errors.New("'reminder_id' not found in url params")
+	}
+	fired, err := sr.popFiredReminder(req, reminderID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if fired == nil {
+		return map[string]interface{}{"snooze_failed": true}, nil, nil
+	}
+	reminder, err := sr.getChatReminder(req, reminderID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if reminder == nil || reminder.ArchivedAs == models.ArchivedDeleted {
+		return map[string]interface{}{"snooze_failed": true}, nil, nil
+	}
+	chat, err := sr.Chats.Get(req.Ctx, req.ChatID)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "chats storage get")
+	}
+	if chat == nil {
+		chat = models.NewChat(req.ChatID, models.DefaultTimezone)
+	}
+	remindAt, err := snoozeTime(req.URL.Params["delay"], time.Now(), chat)
+	if err != nil {
+		return nil, nil, err
+	}
+	snoozed := reminder
+	if reminder.Recurrence != nil {
+		if reminder.AckPending {
+			reminder.Acknowledge()
+			err = finishOccurrence(req, sr.Reminders, sr.Chats, reminder, models.ArchivedDone)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		snoozed = reminder.OneOffCopy(remindAt)
+	} else {
+		// the snoozed reminder doesn't nag until the new time
+		reminder.AckPending, reminder.OccurrenceAt, reminder.NagRepeats = false, nil, 0
+		reminder.RemindAt = remindAt
+		reminder.Restore()
+	}
+	err = sr.Reminders.Save(req.Ctx, snoozed)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "reminders storage save")
+	}
+	data := reminderToData(snoozed, chat)
+	text := fmt.Sprintf("%s\nSnoozed until %s", snoozed.Title, snoozed.RemindAtLocal(chat).Format(snoozedTimeFormat))
+	data["edited"] = sr.editFiredMessage(req, reminderID, text)
+	return data, nil, nil
+}
+
+// doneController stops repeating the fired reminder, a recurring reminder is scheduled to the next occurrence.
+func (sr *ShowReminder) doneController(req *core.Request) (map[string]interface{}, *core.URL, error) {
+	reminderID := req.URL.Params["reminder_id"]
+	if reminderID == "" {
+		return nil, nil, errors.New("'reminder_id' not found in url params")
+	}
+	reminder, err := sr.acknowledge(req, reminderID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if reminder == nil {
+		return map[string]interface{}{"already_done": true}, nil, nil
+	}
+	_, err = sr.popFiredReminder(req, reminderID)
+	if err != nil {
+		return nil, nil, err
+	}
+	data := map[string]interface{}{"title": reminder.Title}
+	data["edited"] = sr.editFiredMessage(req, reminderID, fmt.Sprintf("%s\nDone ✓", reminder.Title))
+	return data, nil, nil
+}
+
+// acknowledge returns nil if the reminder doesn't wait for acknowledgement. The acknowledged one-off reminder
+// is archived as done, a recurring one is moved to the next occurrence.
+func (sr *ShowReminder) acknowledge(req *core.Request, reminderID string) (*models.Reminder, error) {
+	reminder, err := sr.getChatReminder(req, reminderID)
+	if err != nil || reminder == nil || !reminder.AckPending {
+		return nil, err
+	}
+	reminder.Acknowledge()
+	err = finishOccurrence(req, sr.Reminders, sr.Chats, reminder, models.ArchivedDone)
+	return reminder, err
+}
+
+// finishOccurrence saves the reminder whose current occurrence is over. A one-off reminder is archived as kind,
+// a recurring one archives a copy of the occurrence and moves to the next occurrence after now.
+func finishOccurrence(req *core.Request, remindersStorage reminders.Storage, chatsStorage chats.Storage,
+	reminder *models.Reminder, kind string) error {
+
+	now := time.Now().UTC()
+	if reminder.Recurrence != nil {
+		chat, err := chatsStorage.Get(req.Ctx, req.ChatID)
+		if err != nil {
+			return errors.Wrap(err, "chats storage get")
+		}
+		if chat == nil {
+			chat = models.NewChat(req.ChatID, models.DefaultTimezone)
+		}
+		occurrence := reminder.ArchivedOccurrence(kind, now)
+		if reminder.AdvanceAfter(chat, now) {
+			err = remindersStorage.Save(req.Ctx, occurrence)
+			if err != nil {
+				return errors.Wrap(err, "reminders storage save occurrence")
+			}
+			// the new schedule ends a delivery in progress, so the sender doesn't overwrite it
+			reminder.Reschedule()
+			return errors.Wrap(remindersStorage.Save(req.Ctx, reminder), "reminders storage save")
+		}
+	}
+	reminder.Archive(kind, now)
+	return errors.Wrap(remindersStorage.Save(req.Ctx, reminder), "reminders storage save")
+}
+
+func (sr *ShowReminder) editFiredMessage(req *core.Request, reminderID, text string) bool {
+	key := firedMsgIDsKey(reminderID)
+	msgIDs := sr.GetSentMsgIDsByKey(req, key)
+	sr.DeleteStateKey(req, key)
+	if sr.Editor == nil || len(msgIDs) == 0 {
+		return false
+	}
+	// buttons are attached to the last sent message
+	err := sr.Editor.EditMessageText(req.Ctx, req.ChatID, msgIDs[len(msgIDs)-1], text)
+	if err != nil {
+		sr.GetLogger(req.Ctx).Warnf("Cannot edit the fired reminder message: %s", err)
+		return false
+	}
+	return true
+}
+
+func (sr *ShowReminder) storeFiredReminder(req *core.Request, reminder *models.Reminder) error {
+	var fired []*firedReminder
+	err := sr.GetStateAs(req, firedRemindersKey, &fired)
+	if err != nil {
+		return err
+	}
+	// a repeated reminder replaces its previous firing
+	for i, item := range fired {
+		if item.ID == reminder.ID {
+			fired = append(fired[:i], fired[i+1:]...)
+			break
+		}
+	}
+	fired = append(fired, &firedReminder{ID: reminder.ID})
+	if len(fired) > maxFiredReminders {
+		for _, dropped := range fired[:len(fired)-maxFiredReminders] {
+			sr.DeleteStateKey(req, firedMsgIDsKey(dropped.ID))
+		}
+		fired = fired[len(fired)-maxFiredReminders:]
+	}
+	return sr.SetStateAs(req, firedRemindersKey, fired)
+}
+
+// popFiredReminder returns nil if the reminder has been already snoozed or is too old.
+func (sr *ShowReminder) popFiredReminder(req *core.Request, reminderID string) (*firedReminder, error) {
+	var fired []*firedReminder
+	err := sr.GetStateAs(req, firedRemindersKey, &fired)
+	if err != nil {
+		return nil, err
+	}
+	for i, item := range fired {
+		if item.ID != reminderID {
+			continue
+		}
+		fired = append(fired[:i], fired[i+1:]...)
+		return item, sr.SetStateAs(req, firedRemindersKey, fired)
+	}
+	return nil, nil
+}
+
+func firedMsgIDsKey(reminderID string) string {
+	return "fired_msg_ids_" + reminderID
+}
+
+// snoozeTime computes the new remind time, "tomorrow" keeps the local time of day over DST changes.
+func snoozeTime(delay string, now time.Time, chat *models.Chat) (time.Time, error) {
+	if delay == "tomorrow" {
+		return now.In(chat.Location()).AddDate(0, 0, 1).UTC(), nil
+	}
+	duration, ok := snoozeDelays[delay]
+	if !ok {
+		return time.Time{}, errors.Errorf("unknown snooze delay %q", delay)
+	}
+	return now.Add(duration).UTC(), nil
 }
 
 func (sr *ShowReminder) showController(req *core.Request) (map[string]interface{}, *core.URL, error) {
@@ -53,9 +290,9 @@ func (sr *ShowReminder) showController(req *core.Request) (map[string]interface{
 	if reminderID == "" {
 		return nil, nil, errors.New("'reminder_id' not found in url params")
 	}
-	reminder, err := sr.Reminders.Get(req.Ctx, reminderID)
+	reminder, err := sr.getChatReminder(req, reminderID)
 	if err != nil {
-		return nil, nil, errors.Wrap(err, "reminders storage get failed")
+		return nil, nil, err
 	}
 	if reminder == nil {
 		return map[string]interface{}{"reminder_not_found": true}, nil, nil
@@ -63,13 +300,63 @@ func (sr *ShowReminder) showController(req *core.Request) (map[string]interface{
 	return reminderToData(reminder, chat), nil, nil
 }
 
+// getChatReminder returns nil if there is no such reminder in the chat of the request. Button urls are signed,
+// but the id can still come from the user, e.g in a deep link, so it must not open reminders of other chats.
+func (sr *ShowReminder) getChatReminder(req *core.Request, reminderID string) (*models.Reminder, error) {
+	reminder, err := sr.Reminders.Get(req.Ctx, reminderID)
+	if err != nil {
+		return nil, errors.Wrap(err, "reminders storage get")
+	}
+	if reminder == nil || reminder.ChatID != req.ChatID {
+		return nil, nil
+	}
+	return reminder, nil
+}
+
+// retryController schedules the reminder whose delivery failed again, it's delivered at once if its time has passed.
+func (sr *ShowReminder) retryController(req *core.Request) (map[string]interface{}, *core.URL, error) {
+	reminderID := req.URL.Params["reminder_id"]
+	if reminderID == "" {
+		return nil, nil, errors.New("'reminder_id' not found in url params")
+	}
+	reminder, err := sr.getChatReminder(req, reminderID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if reminder == nil || reminder.IsArchived() {
+		return map[string]interface{}{"reminder_not_found": true}, nil, nil
+	}
+	if !reminder.Failed {
+		return map[string]interface{}{"title": reminder.Title}, nil, nil
+	}
+	sr.GetLogger(req.Ctx).WithField("reminder_id", reminder.ID).Infof("Retry the failed reminder: %s",
+		reminder.LastError)
+	reminder.Reschedule()
+	err = sr.Reminders.Save(req.Ctx, reminder)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "reminders storage save")
+	}
+	return map[string]interface{}{"title": reminder.Title, "rescheduled": true}, nil, nil
+}
+
 func reminderToData(reminder *models.Reminder, chat *models.Chat) map[string]interface{} {
-	data := map[string]interface{}{"title": reminder.Title}
+	data := map[string]interface{}{"reminder_id": reminder.ID, "title": reminder.Title, "recurrence": ""}
+	if reminder.Recurrence != nil {
+		data["recurrence"] = reminder.Recurrence.Describe()
+	}
+	data["tags"] = formatTags(reminder.Tags, "")
+	data["assignee"] = reminder.Mention()
+	data["nagging"] = reminder.NagInterval > 0
+	data["notify_before"] = describeNotifyBefore(reminder.NotifyBefore)
+	data["nag_interval"] = describeNagInterval(reminder)
+	data["failed"] = reminder.Failed
+	data["last_error"] = reminder.LastError
 	if reminder.Description != nil {
 		data["description"] = *reminder.Description
 	} else {
 		data["description"] = ""
 	}
+	data["archived"] = ""
 	if chat == nil {
 		data["created_at"] = reminder.CreatedAt
 		data["remind_at"] = reminder.RemindAt
@@ -77,5 +364,59 @@ func reminderToData(reminder *models.Reminder, chat *models.Chat) map[string]int
 		data["created_at"] = reminder.CreatedAtLocal(chat)
 		data["remind_at"] = reminder.RemindAtLocal(chat)
 	}
+	if reminder.IsArchived() {
+		data["archived"] = fmt.Sprintf("%s at %s", reminder.ArchivedAs,
+			reminder.ArchivedAt.In(chatLocation(chat)).Format(confirmationTimeFormat))
+	}
 	return data
 }
+
+// describeNagInterval returns an empty string if the reminder isn't repeated until acknowledged.
+func describeNagInterval(reminder *models.Reminder) string {
+	if reminder.NagInterval <= 0 {
+		return ""
+	}
+	description := fmt.Sprintf("every %d minutes until done", int(reminder.NagInterval/time.Minute))
+	if reminder.NagMaxRepeats > 0 {
+		description += fmt.Sprintf(", at most %d times", reminder.NagMaxRepeats)
+	}
+	return description
+}
+
+// describeNotifyBefore returns an empty string if there are no advance notifications.
+func describeNotifyBefore(notifyBefore []time.Duration) string {
+	descriptions := make([]string, len(notifyBefore))
+	for i, before := range notifyBefore {
+		descriptions[i] = describeDuration(before)
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+// describeDuration formats a duration like "1 day 2 hours" with minutes precision.
+func describeDuration(d time.Duration) string {
+	units := []struct {
+		name     string
+		duration time.Duration
+	}{{"day", 24 * time.Hour}, {"hour", time.Hour}, {"minute", time.Minute}}
+	var parts []string
+	for _, unit := range units {
+		n := int(d / unit.duration)
+		d -= time.Duration(n) * unit.duration
+		if n == 1 {
+			parts = append(parts, "1 "+unit.name)
+		} else if n > 1 {
+			parts = append(parts, fmt.Sprintf("%d %ss", n, unit.name))
+		}
+	}
+	if len(parts) == 0 {
+		return "less than a minute"
+	}
+	return strings.Join(parts, " ")
+}
+
+func chatLocation(chat *models.Chat) *time.Location {
+	if chat == nil {
+		return time.UTC
+	}
+	return chat.Location()
+}
@@ -0,0 +1,251 @@
+package recurrence
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+	Yearly  Frequency = "YEARLY"
+
+	rrulePrefix      = "RRULE:"
+	untilFormat      = "20060102T150405Z"
+	untilDateFormat  = "20060102"
+	maxSkippedPeriod = 12 * 8
+)
+
+var (
+	weekdayCodes = map[string]time.Weekday{
+		"MO": time.Monday, "TU": time.Tuesday, "WE": time.Wednesday, "TH": time.Thursday, "FR": time.Friday,
+		"SA": time.Saturday, "SU": time.Sunday,
+	}
+	frequencyUnits = map[Frequency]string{Daily: "day", Weekly: "week", Monthly: "month", Yearly: "year"}
+)
+
+// Rule is a subset of RFC 5545 recurrence rule: FREQ, INTERVAL, BYDAY for weekly rules, COUNT and UNTIL.
+// Occurrences are computed in the wall clock of the given location, so they keep the time of day over DST changes.
+type Rule struct {
+	Freq     Frequency
+	Interval int
+	Weekdays []time.Weekday
+	Count    int
+	Until    *time.Time
+	// TimeOfDay is the wall clock of occurrences since midnight, it keeps the time of day when an occurrence
+	// falls in a DST gap and is moved. It isn't a part of the rule string, the time of the previous
+	// occurrence is used if it's nil.
+	TimeOfDay *time.Duration
+}
+
+func NewRule(freq Frequency) *Rule {
+	return &Rule{Freq: freq, Interval: 1}
+}
+
+// Parse parses a rule like "FREQ=WEEKLY;BYDAY=MO,WE;COUNT=10", the "RRULE:" prefix is optional.
+func Parse(s string) (*Rule, error) {
+	s = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), rrulePrefix)
+	if s == "" {
+		return nil, errors.New("empty recurrence rule")
+	}
+	rule := &Rule{Interval: 1}
+	for _, part := range strings.Split(s, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, errors.Errorf("bad rule part %q", part)
+		}
+		key, value := kv[0], kv[1]
+		switch key {
+		case "FREQ":
+			freq := Frequency(value)
+			if _, ok := frequencyUnits[freq]; !ok {
+				return nil, errors.Errorf("unsupported frequency %s", value)
+			}
+			rule.Freq = freq
+		case "INTERVAL":
+			interval, err := strconv.Atoi(value)
+			if err != nil || interval < 1 {
+				return nil, errors.Errorf("interval must be a positive number, got %s", value)
+			}
+			rule.Interval = interval
+		case "COUNT":
+			count, err := strconv.Atoi(value)
+			if err != nil || count < 1 {
+				return nil, errors.Errorf("count must be a positive number, got %s", value)
+			}
+			rule.Count = count
+		case "UNTIL":
+			until, err := time.Parse(untilFormat, value)
+			if err != nil {
+				until, err = time.Parse(untilDateFormat, value)
+				if err != nil {
+					return nil, errors.Errorf("until must be in %s or %s format, got %s", untilFormat,
+						untilDateFormat, value)
+				}
+				until = until.Add(24*time.Hour - time.Second)
+			}
+			rule.Until = &until
+		case "BYDAY":
+			for _, code := range strings.Split(value, ",") {
+				weekday, ok := weekdayCodes[code]
+				if !ok {
+					return nil, errors.Errorf("unsupported weekday %s", code)
+				}
+				rule.Weekdays = append(rule.Weekdays, weekday)
+			}
+		default:
+			return nil, errors.Errorf("unsupported rule part %s", key)
+		}
+	}
+	if rule.Freq == "" {
+		return nil, errors.New("FREQ is required")
+	}
+	if rule.Count != 0 && rule.Until != nil {
+		return nil, errors.New("COUNT and UNTIL can't be used together")
+	}
+	if len(rule.Weekdays) != 0 && rule.Freq != Weekly {
+		return nil, errors.New("BYDAY is supported only for weekly rules")
+	}
+	return rule, nil
+}
+
+// String returns the rule in RFC 5545 format without the "RRULE:" prefix.
+func (r *Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.Weekdays) != 0 {
+		codes := make([]string, len(r.Weekdays))
+		for i, weekday := range r.Weekdays {
+			codes[i] = weekdayCode(weekday)
+		}
+		parts = append(parts, "BYDAY="+strings.Join(codes, ","))
+	}
+	if r.Count != 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if r.Until != nil {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format(untilFormat))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Describe returns a human readable rule, e.g "every 2 weeks on Mon, Fri, 10 times".
+func (r *Rule) Describe() string {
+	unit := frequencyUnits[r.Freq]
+	var text string
+	if r.Interval > 1 {
+		text = fmt.Sprintf("every %d %ss", r.Interval, unit)
+	} else {
+		text = "every " + unit
+	}
+	if len(r.Weekdays) != 0 {
+		names := make([]string, len(r.Weekdays))
+		for i, weekday := range r.Weekdays {
+			names[i] = weekday.String()[:3]
+		}
+		text += " on " + strings.Join(names, ", ")
+	}
+	if r.Count != 0 {
+		text += fmt.Sprintf(", %d times", r.Count)
+	}
+	if r.Until != nil {
+		text += ", until " + r.Until.Format("2006-01-02")
+	}
+	return text
+}
+
+// Next returns the occurrence following the previous one, fired is the number of already fired occurrences.
+// The second result is false if the recurrence is over.
+func (r *Rule) Next(previous time.Time, fired int, loc *time.Location) (time.Time, bool) {
+	if r.Count != 0 && fired >= r.Count {
+		return time.Time{}, false
+	}
+	local := previous.In(loc)
+	var next time.Time
+	switch r.Freq {
+	case Daily:
+		next = local.AddDate(0, 0, r.Interval)
+	case Weekly:
+		next = r.nextWeekly(local)
+	case Monthly:
+		next = nextSameDay(local, 0, r.Interval)
+	case Yearly:
+		next = nextSameDay(local, r.Interval, 0)
+	}
+	if next.IsZero() {
+		return time.Time{}, false
+	}
+	if r.TimeOfDay != nil {
+		next = atTimeOfDay(next, *r.TimeOfDay)
+	}
+	if r.Until != nil && next.After(*r.Until) {
+		return time.Time{}, false
+	}
+	return next.UTC(), true
+}
+
+func (r *Rule) nextWeekly(local time.Time) time.Time {
+	if len(r.Weekdays) == 0 {
+		return local.AddDate(0, 0, 7*r.Interval)
+	}
+	weekdays := make(map[time.Weekday]bool, len(r.Weekdays))
+	for _, weekday := range r.Weekdays {
+		weekdays[weekday] = true
+	}
+	// the rest of the current week, weeks start on monday
+	daysToWeekEnd := 6 - (int(local.Weekday())+6)%7
+	for d := 1; d <= daysToWeekEnd; d++ {
+		candidate := local.AddDate(0, 0, d)
+		if weekdays[candidate.Weekday()] {
+			return candidate
+		}
+	}
+	weekStart := local.AddDate(0, 0, -(int(local.Weekday())+6)%7+7*r.Interval)
+	for d := 0; d < 7; d++ {
+		candidate := weekStart.AddDate(0, 0, d)
+		if weekdays[candidate.Weekday()] {
+			return candidate
+		}
+	}
+	return time.Time{}
+}
+
+// nextSameDay skips months and years that don't have the day of the month, e.g 31st or February 29th.
+func nextSameDay(local time.Time, years, months int) time.Time {
+	for i := 1; i <= maxSkippedPeriod; i++ {
+		candidate := time.Date(local.Year()+years*i, local.Month()+time.Month(months*i), 1, local.Hour(),
+			local.Minute(), local.Second(), local.Nanosecond(), local.Location())
+		if daysIn(candidate) < local.Day() {
+			continue
+		}
+		return candidate.AddDate(0, 0, local.Day()-1)
+	}
+	return time.Time{}
+}
+
+func atTimeOfDay(local time.Time, timeOfDay time.Duration) time.Time {
+	return time.Date(local.Year(), local.Month(), local.Day(), int(timeOfDay/time.Hour),
+		int(timeOfDay%time.Hour/time.Minute), int(timeOfDay%time.Minute/time.Second), 0, local.Location())
+}
+
+func daysIn(monthStart time.Time) int {
+	return time.Date(monthStart.Year(), monthStart.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func weekdayCode(weekday time.Weekday) string {
+	for code, w := range weekdayCodes {
+		if w == weekday {
+			return code
+		}
+	}
+	return ""
+}
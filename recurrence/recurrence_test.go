@@ -0,0 +1,174 @@
+package recurrence_test
+
+import (
+	"testing"
+	"time"
+
+	"reminder/recurrence"
+)
+
+func loadBerlin(t *testing.T) *time.Location {
+	loc, err := time.LoadLocation("Europe/Berlin")
+	if err != nil {
+		t.Fatalf("load location: %s", err)
+	}
+	return loc
+}
+
+func TestParseString(t *testing.T) {
+	cases := []struct {
+		text     string
+		expected string
+	}{
+		{"FREQ=DAILY", "FREQ=DAILY"},
+		{"RRULE:freq=weekly;byday=mo,we;count=10", "FREQ=WEEKLY;BYDAY=MO,WE;COUNT=10"},
+		{"FREQ=DAILY;INTERVAL=1", "FREQ=DAILY"},
+		{"FREQ=WEEKLY;INTERVAL=2", "FREQ=WEEKLY;INTERVAL=2"},
+		{"FREQ=MONTHLY;UNTIL=20301231T120000Z", "FREQ=MONTHLY;UNTIL=20301231T120000Z"},
+		{"FREQ=YEARLY;UNTIL=20301231", "FREQ=YEARLY;UNTIL=20301231T235959Z"},
+	}
+	for _, c := range cases {
+		t.Run(c.text, func(t *testing.T) {
+			rule, err := recurrence.Parse(c.text)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if rule.String() != c.expected {
+				t.Fatalf("expected %s, got %s", c.expected, rule.String())
+			}
+			reparsed, err := recurrence.Parse(rule.String())
+			if err != nil {
+				t.Fatalf("parse of %s: %s", rule.String(), err)
+			}
+			if reparsed.String() != c.expected {
+				t.Errorf("expected %s after the round trip, got %s", c.expected, reparsed.String())
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, text := range []string{"", "RRULE:", "FREQ", "FREQ=", "FREQ=HOURLY", "INTERVAL=2", "FREQ=DAILY;INTERVAL=0",
+		"FREQ=DAILY;COUNT=-1", "FREQ=DAILY;UNTIL=tomorrow", "FREQ=DAILY;COUNT=2;UNTIL=20300101",
+		"FREQ=DAILY;BYDAY=MO", "FREQ=WEEKLY;BYDAY=XX", "FREQ=DAILY;BYMONTH=1"} {
+		t.Run(text, func(t *testing.T) {
+			rule, err := recurrence.Parse(text)
+			if err == nil {
+				t.Errorf("expected an error, got %s", rule)
+			}
+		})
+	}
+}
+
+func TestNext(t *testing.T) {
+	loc := loadBerlin(t)
+	local := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, loc)
+	}
+	cases := []struct {
+		name     string
+		rule     string
+		previous time.Time
+		fired    int
+		// expected is zero if the recurrence is over
+		expected time.Time
+	}{
+		{"daily", "FREQ=DAILY", local(2030, 1, 10, 9, 0), 1, local(2030, 1, 11, 9, 0)},
+		{"daily interval", "FREQ=DAILY;INTERVAL=3", local(2030, 1, 30, 9, 0), 1, local(2030, 2, 2, 9, 0)},
+		{"daily over dst", "FREQ=DAILY", local(2030, 3, 30, 9, 0), 1, local(2030, 3, 31, 9, 0)},
+		{"weekly", "FREQ=WEEKLY", local(2030, 1, 7, 9, 0), 1, local(2030, 1, 14, 9, 0)},
+		{"weekly same week", "FREQ=WEEKLY;BYDAY=MO,FR", local(2030, 1, 9, 10, 0), 1, local(2030, 1, 11, 10, 0)},
+		{"weekly next week", "FREQ=WEEKLY;BYDAY=MO,FR", local(2030, 1, 11, 10, 0), 1, local(2030, 1, 14, 10, 0)},
+		{"weekly start on sunday", "FREQ=WEEKLY;BYDAY=TU,TH", local(2030, 1, 13, 8, 0), 0,
+			local(2030, 1, 15, 8, 0)},
+		{"weekly interval start not on a listed day", "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE", local(2030, 1, 10, 8, 0),
+			0, local(2030, 1, 21, 8, 0)},
+		{"monthly", "FREQ=MONTHLY", local(2030, 1, 15, 9, 0), 1, local(2030, 2, 15, 9, 0)},
+		{"31st skips february", "FREQ=MONTHLY", local(2030, 1, 31, 9, 0), 1, local(2030, 3, 31, 9, 0)},
+		{"31st skips 30 days month", "FREQ=MONTHLY", local(2030, 3, 31, 9, 0), 1, local(2030, 5, 31, 9, 0)},
+		{"30th in february", "FREQ=MONTHLY;INTERVAL=1", local(2030, 1, 30, 9, 0), 1, local(2030, 3, 30, 9, 0)},
+		{"yearly", "FREQ=YEARLY", local(2030, 6, 1, 9, 0), 1, local(2031, 6, 1, 9, 0)},
+		{"leap day", "FREQ=YEARLY", local(2028, 2, 29, 9, 0), 1, local(2032, 2, 29, 9, 0)},
+		{"count left", "FREQ=DAILY;COUNT=3", local(2030, 1, 10, 9, 0), 2, local(2030, 1, 11, 9, 0)},
+		{"count exhausted", "FREQ=DAILY;COUNT=3", local(2030, 1, 10, 9, 0), 3, time.Time{}},
+		{"until left", "FREQ=DAILY;UNTIL=20300115T080000Z", local(2030, 1, 14, 9, 0), 1, local(2030, 1, 15, 9, 0)},
+		{"until exhausted", "FREQ=DAILY;UNTIL=20300115T075959Z", local(2030, 1, 14, 9, 0), 1, time.Time{}},
+		{"until date includes the day", "FREQ=WEEKLY;UNTIL=20300121", local(2030, 1, 14, 23, 0), 1,
+			local(2030, 1, 21, 23, 0)},
+		{"until date exhausted", "FREQ=WEEKLY;UNTIL=20300121", local(2030, 1, 15, 9, 0), 1, time.Time{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			rule, err := recurrence.Parse(c.rule)
+			if err != nil {
+				t.Fatalf("parse: %s", err)
+			}
+			next, ok := rule.Next(c.previous.UTC(), c.fired, loc)
+			if c.expected.IsZero() {
+				if ok {
+					t.Errorf("expected the recurrence to be over, got %s", next.In(loc))
+				}
+				return
+			}
+			if !ok {
+				t.Fatal("expected an occurrence, the recurrence is over")
+			}
+			if !next.Equal(c.expected) {
+				t.Errorf("expected %s, got %s", c.expected, next.In(loc))
+			}
+			if next.Location() != time.UTC {
+				t.Errorf("expected utc result, got %s", next.Location())
+			}
+		})
+	}
+}
+
+// 2:30 doesn't exist in Berlin on 2030-03-31, the occurrence is moved, but the following ones are at 2:30 again
+func TestNextDSTGap(t *testing.T) {
+	loc := loadBerlin(t)
+	cases := []struct {
+		rule     string
+		previous time.Time
+		expected []time.Time
+	}{
+		{"FREQ=DAILY", time.Date(2030, 3, 30, 2, 30, 0, 0, loc), []time.Time{
+			time.Date(2030, 3, 31, 2, 30, 0, 0, loc),
+			time.Date(2030, 4, 1, 2, 30, 0, 0, loc),
+			time.Date(2030, 4, 2, 2, 30, 0, 0, loc),
+		}},
+		{"FREQ=WEEKLY;BYDAY=SU,MO", time.Date(2030, 3, 25, 2, 30, 0, 0, loc), []time.Time{
+			time.Date(2030, 3, 31, 2, 30, 0, 0, loc),
+			time.Date(2030, 4, 1, 2, 30, 0, 0, loc),
+			time.Date(2030, 4, 7, 2, 30, 0, 0, loc),
+		}},
+		{"FREQ=MONTHLY", time.Date(2030, 1, 31, 2, 30, 0, 0, loc), []time.Time{
+			time.Date(2030, 3, 31, 2, 30, 0, 0, loc),
+			time.Date(2030, 5, 31, 2, 30, 0, 0, loc),
+			time.Date(2030, 7, 31, 2, 30, 0, 0, loc),
+		}},
+	}
+	for _, c := range cases {
+		t.Run(c.rule, func(t *testing.T) {
+			rule, err := recurrence.Parse(c.rule)
+			if err != nil {
+				t.Fatalf("parse: %s", err)
+			}
+			timeOfDay := 2*time.Hour + 30*time.Minute
+			rule.TimeOfDay = &timeOfDay
+			previous := c.previous
+			for i, expected := range c.expected {
+				next, ok := rule.Next(previous, i, loc)
+				if !ok {
+					t.Fatalf("expected occurrence %d, the recurrence is over", i+1)
+				}
+				if !next.Equal(expected) {
+					t.Fatalf("expected occurrence %d at %s, got %s", i+1, expected, next.In(loc))
+				}
+				previous = next
+			}
+			if gap := c.expected[0].In(loc); gap.Hour() != 3 || gap.Minute() != 30 {
+				t.Errorf("expected the occurrence in the gap at 3:30, got %s", gap)
+			}
+		})
+	}
+}
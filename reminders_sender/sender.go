@@ -6,11 +6,14 @@ import (
 	"reminder/core/presenter"
 	"reminder/models"
 	"reminder/pages"
+	"reminder/storages/chats"
 	"reminder/storages/reminders"
 	"sync"
+	"time"
 
 	"github.com/gazoon/bot_libs/logging"
 	"github.com/gazoon/bot_libs/utils"
+	"github.com/pkg/errors"
 )
 
 var (
@@ -21,20 +24,123 @@ type Sender struct {
 	*logging.ObjectLogger
 	presenter  *presenter.UIPresenter
 	source     reminders.Reader
+	reminders  reminders.Storage
+	chats      chats.Storage
 	workersNum int
 	wg         sync.WaitGroup
 }
 
-func NewSender(presenter *presenter.UIPresenter, source reminders.Reader, workersNum int) *Sender {
+func NewSender(presenter *presenter.UIPresenter, source reminders.Reader, remindersStorage reminders.Storage,
+	chatsStorage chats.Storage, workersNum int) *Sender {
+
 	logger := logging.NewObjectLogger("reminders_sender", nil)
-	return &Sender{presenter: presenter, source: source, workersNum: workersNum, ObjectLogger: logger}
+	return &Sender{presenter: presenter, source: source, reminders: remindersStorage, chats: chatsStorage,
+		workersNum: workersNum, ObjectLogger: logger}
 }
 
+// onReminder completes the delivery only if the reminder has been shown, otherwise it's retried by the source.
 func (s *Sender) onReminder(ctx context.Context, reminder *models.Reminder) {
-	s.GetLogger(ctx).Info("Reminder received: %s", reminder)
+	logger := s.GetLogger(ctx).WithField("reminder_id", reminder.ID)
+	logger.Infof("Reminder received, attempt %d: %s", reminder.Attempts, reminder)
+	now := time.Now()
+	msg := &pages.ReminderReadyMessage{Reminder: reminder}
+	if reminder.IsAdvanceNotification(now) {
+		// a late notification, e.g after a retry, tells the time actually left,
+		// it's delivered as the due one if less than a minute is left
+		msg.Ahead = reminder.RemindAt.Sub(now).Round(time.Minute)
+	}
+	isAdvance := msg.Ahead > 0
 	showURL := core.NewURL("show_reminder", "when_ready", nil)
-	req := &core.Request{Ctx: ctx, Msg: &pages.ReminderReadyMessage{reminder}, ChatID: reminder.ChatID, URL: showURL}
-	s.presenter.HandleRequest(req)
+	req := &core.Request{Ctx: ctx, Msg: msg, ChatID: reminder.ChatID, URL: showURL}
+	ok := s.presenter.HandleRequest(req)
+	if !ok {
+		logger.Warn("Reminder delivery failed")
+		err := s.source.Nack(ctx, reminder, errors.New("reminder request handling failed"))
+		if err != nil {
+			logger.Errorf("Cannot schedule the delivery retry: %s", err)
+		}
+		return
+	}
+	if isAdvance {
+		// requeueing schedules the following notification of the reminder
+		err := s.requeue(ctx, reminder)
+		if err != nil {
+			logger.Errorf("Cannot schedule the notification after the advance one: %s", err)
+		}
+		return
+	}
+	scheduled, err := s.scheduleNag(ctx, reminder)
+	if err == nil && !scheduled {
+		scheduled, err = s.scheduleNext(ctx, reminder)
+	}
+	if err != nil {
+		// the reminder stays claimed, it's delivered again when the lease expires
+		logger.Errorf("Cannot schedule the next occurrence: %s", err)
+		return
+	}
+	if scheduled {
+		return
+	}
+	err = s.source.Ack(ctx, reminder)
+	if err != nil {
+		logger.Errorf("Cannot acknowledge the reminder delivery: %s", err)
+	}
+}
+
+// scheduleNag saves the reminder with the remind time of the next repeat if it nags until acknowledged.
+func (s *Sender) scheduleNag(ctx context.Context, reminder *models.Reminder) (bool, error) {
+	if !reminder.Nag(time.Now().UTC()) {
+		return false, nil
+	}
+	s.GetLogger(ctx).WithField("reminder_id", reminder.ID).Infof("Repeat %d until acknowledged at %s",
+		reminder.NagRepeats+1, reminder.RemindAt)
+	err := s.requeue(ctx, reminder)
+	return err == nil, err
+}
+
+// scheduleNext saves the recurring reminder back with the remind time of the next occurrence,
+// the first result is false if the reminder isn't recurring or its recurrence is over.
+func (s *Sender) scheduleNext(ctx context.Context, reminder *models.Reminder) (bool, error) {
+	if reminder.Recurrence == nil {
+		return false, nil
+	}
+	logger := s.GetLogger(ctx).WithField("reminder_id", reminder.ID)
+	chat, err := s.chats.Get(ctx, reminder.ChatID)
+	if err != nil {
+		return false, errors.Wrap(err, "chats storage get")
+	}
+	// the last occurrence is archived with the reminder itself
+	now := time.Now().UTC()
+	occurrence := reminder.ArchivedOccurrence(models.ArchivedFired, now)
+	firedCount := reminder.FiredCount
+	if !reminder.AdvanceAfter(chat, now) {
+		logger.Info("Reminder recurrence is over")
+		return false, nil
+	}
+	if skipped := reminder.FiredCount - firedCount - 1; skipped > 0 {
+		logger.Warnf("Skip %d missed occurrences", skipped)
+	}
+	err = s.reminders.Save(ctx, occurrence)
+	if err != nil {
+		// the history is less important than the next occurrence
+		logger.Errorf("Cannot archive the fired occurrence: %s", err)
+	}
+	logger.Infof("Schedule the next occurrence at %s", reminder.RemindAt)
+	err = s.requeue(ctx, reminder)
+	return err == nil, err
+}
+
+// requeue saves the new schedule of the delivered reminder, it's skipped if the reminder has been changed
+// by the user during the delivery, e.g snoozed or marked as done, the user's change wins then.
+func (s *Sender) requeue(ctx context.Context, reminder *models.Reminder) error {
+	requeued, err := s.source.Requeue(ctx, reminder)
+	if err != nil {
+		return errors.Wrap(err, "reminders requeue")
+	}
+	if !requeued {
+		s.GetLogger(ctx).WithField("reminder_id", reminder.ID).Info("Reminder is changed during the delivery, keep the change")
+	}
+	return nil
 }
 
 func (s *Sender) Start() {
@@ -39,16 +39,44 @@ func main() {
 	if err != nil {
 		panic(err)
 	}
+	// the delivery leases and the text search rely on the reminders indexes
+	err = env.EnsureRemindersIndexes()
+	if err != nil {
+		panic(err)
+	}
 	chatsStorage, err := env.CreateMongoChatsStorage()
 	if err != nil {
 		panic(err)
 	}
-	presenter, err := env.CreateUIPresenter(telegramMessenger, remindersStorage, chatsStorage)
+	migratedChats, err := chatsStorage.MigrateLegacyTimezones(utils.PrepareContext(logging.NewRequestID()))
+	if err != nil {
+		gLogger.Errorf("Cannot migrate chats timezones: %s", err)
+	}
+	gLogger.Infof("Chats with legacy timezones migrated: %d", migratedChats)
+	sessionsStorage, err := env.CreateMongoSessionsStorage()
+	if err != nil {
+		panic(err)
+	}
+	// conflict detection on session saves relies on the unique chat_id index
+	err = env.EnsureSessionsIndexes()
 	if err != nil {
 		panic(err)
 	}
+	presenter, err := env.CreateUIPresenter(telegramMessenger, sessionsStorage, remindersStorage, chatsStorage)
+	if err != nil {
+		panic(err)
+	}
+	err = env.PublishBotCommands(utils.PrepareContext(logging.NewRequestID()), presenter.Commands())
+	if err != nil {
+		gLogger.Errorf("Cannot publish bot commands: %s", err)
+	}
 	readerService := msgsqueue.NewReader(incomingQueue, conf.MongoMessages.WorkersNum, presenter.OnQueueMessage)
-	remindersSenderService := remsender.NewSender(presenter, remindersStorage, conf.MongoReminders.WorkersNum)
+	remindersSenderService := remsender.NewSender(presenter, remindersStorage, remindersStorage, chatsStorage,
+		conf.MongoReminders.WorkersNum)
+	sessionsCleanerService, err := env.CreateSessionsCleaner(sessionsStorage)
+	if err != nil {
+		panic(err)
+	}
 	gLogger.Info("Starting bot service")
 	readerService.Start()
 	defer readerService.Stop()
@@ -59,6 +87,8 @@ func main() {
 	}
 	remindersSenderService.Start()
 	defer remindersSenderService.Stop()
+	sessionsCleanerService.Start()
+	defer sessionsCleanerService.Stop()
 	gLogger.Info("Server successfully started")
 	utils.WaitingForShutdown()
 }
@@ -0,0 +1,75 @@
+package sesscleaner
+
+import (
+	"reminder/core"
+	"sync"
+	"time"
+
+	"github.com/gazoon/bot_libs/logging"
+	"github.com/gazoon/bot_libs/utils"
+	"github.com/pkg/errors"
+)
+
+var (
+	gLogger = logging.WithPackage("sessions_cleaner")
+)
+
+// Cleaner periodically removes sessions of the chats that haven't interacted with the bot for a long time.
+type Cleaner struct {
+	*logging.ObjectLogger
+	storage  core.Storage
+	ttl      time.Duration
+	interval time.Duration
+	stop     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewCleaner fails for non-positive durations: a zero ttl would delete every session
+// and the ticker doesn't accept a zero interval.
+func NewCleaner(storage core.Storage, ttl, interval time.Duration) (*Cleaner, error) {
+	if ttl <= 0 {
+		return nil, errors.Errorf("sessions ttl must be positive, got %s", ttl)
+	}
+	if interval <= 0 {
+		return nil, errors.Errorf("cleanup interval must be positive, got %s", interval)
+	}
+	logger := logging.NewObjectLogger("sessions_cleaner", nil)
+	return &Cleaner{storage: storage, ttl: ttl, interval: interval, stop: make(chan struct{}), ObjectLogger: logger},
+		nil
+}
+
+func (c *Cleaner) clean() {
+	ctx := utils.PrepareContext(logging.NewRequestID())
+	before := time.Now().UTC().Add(-c.ttl)
+	logger := c.GetLogger(ctx).WithField("before", before)
+	logger.Info("Delete inactive sessions")
+	err := c.storage.DeleteInactive(ctx, before)
+	if err != nil {
+		logger.Errorf("Cannot delete inactive sessions: %s", err)
+	}
+}
+
+func (c *Cleaner) Start() {
+	gLogger.WithField("interval", c.interval).Info("Start cleaning inactive sessions")
+	c.wg.Add(1)
+	go func() {
+		defer c.wg.Done()
+		ticker := time.NewTicker(c.interval)
+		defer ticker.Stop()
+		for {
+			c.clean()
+			select {
+			case <-ticker.C:
+			case <-c.stop:
+				return
+			}
+		}
+	}()
+}
+
+func (c *Cleaner) Stop() {
+	gLogger.Info("Stop cleaning inactive sessions")
+	close(c.stop)
+	c.wg.Wait()
+	gLogger.Info("Cleaner've been stopped")
+}
@@ -3,7 +3,11 @@ package chats
 import (
 	"context"
 	"reminder/models"
+	"reminder/timezones"
+	"sync"
+	"time"
 
+	"github.com/gazoon/bot_libs/logging"
 	"github.com/gazoon/bot_libs/mongo"
 	"github.com/gazoon/bot_libs/utils"
 	"github.com/pkg/errors"
@@ -12,11 +16,42 @@ import (
 	"github.com/globalsign/mgo"
 )
 
+var (
+	gLogger = logging.WithPackage("chats")
+)
+
 type Storage interface {
 	Get(ctx context.Context, ChatID int) (*models.Chat, error)
 	Save(ctx context.Context, chat *models.Chat) error
 }
 
+type InMemoryStorage struct {
+	mx      sync.RWMutex
+	storage map[int]*Chat
+}
+
+func NewInMemoryStorage() *InMemoryStorage {
+	return &InMemoryStorage{storage: make(map[int]*Chat)}
+}
+
+func (ms *InMemoryStorage) Get(ctx context.Context, chatID int) (*models.Chat, error) {
+	ms.mx.RLock()
+	data, ok := ms.storage[chatID]
+	ms.mx.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+	return data.toModel()
+}
+
+func (ms *InMemoryStorage) Save(ctx context.Context, chat *models.Chat) error {
+	data := DataFromModel(chat)
+	ms.mx.Lock()
+	ms.storage[chat.ID] = data
+	ms.mx.Unlock()
+	return nil
+}
+
 type MongoStorage struct {
 	client *mongo.Client
 }
@@ -50,9 +85,38 @@ func (ms *MongoStorage) Save(ctx context.Context, chat *models.Chat) error {
 	return errors.Wrap(err, "mongo upsert")
 }
 
+// MigrateLegacyTimezones rewrites chats saved with an hours offset timezone, chats are converted on load anyway,
+// so the migration only cleans up the stored documents. Chats that fail are logged and skipped,
+// the result is the number of migrated chats.
+func (ms *MongoStorage) MigrateLegacyTimezones(ctx context.Context) (int, error) {
+	var legacy []*Chat
+	err := ms.client.Find(ctx, bson.M{"timezone": bson.M{"$exists": true}}, "", 0, 0, &legacy)
+	if err != nil {
+		return 0, errors.Wrap(err, "mongo find")
+	}
+	migrated := 0
+	for _, data := range legacy {
+		logger := gLogger.WithField("chat_id", data.ChatID)
+		chat, err := data.toModel()
+		if err != nil {
+			logger.Errorf("Cannot convert the legacy chat: %s", err)
+			continue
+		}
+		err = ms.Save(ctx, chat)
+		if err != nil {
+			logger.Errorf("Cannot save the migrated chat: %s", err)
+			continue
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
 type Chat struct {
-	ChatID   int `bson:"chat_id"`
-	Timezone int `bson:"timezone"`
+	ChatID   int    `bson:"chat_id"`
+	Timezone string `bson:"tz"`
+	// LegacyTimezone is the hours offset chats were saved with before zone names
+	LegacyTimezone *int `bson:"timezone,omitempty"`
 }
 
 func DataFromModel(m *models.Chat) *Chat {
@@ -67,8 +131,28 @@ func (c *Chat) toModel() (*models.Chat, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "bad data for chat")
 	}
+	timezone := c.Timezone
+	if timezone == "" && c.LegacyTimezone != nil {
+		timezone = legacyTimezone(c.ChatID, *c.LegacyTimezone)
+	}
+	_, err = timezones.Load(timezone)
+	if err != nil {
+		return nil, errors.Wrap(err, "bad chat timezone")
+	}
 	return &models.Chat{
 		ID:       c.ChatID,
-		Timezone: c.Timezone,
+		Timezone: timezone,
 	}, nil
 }
+
+// legacyTimezone converts the hours offset, offsets out of the real range, e.g minutes typed by mistake,
+// can't be loaded and are replaced by the default timezone.
+func legacyTimezone(chatID, hours int) string {
+	timezone := timezones.FormatOffset(time.Duration(hours) * time.Hour)
+	if _, err := timezones.Load(timezone); err != nil {
+		gLogger.WithField("chat_id", chatID).Warnf("Legacy timezone offset %d is out of range, use %s",
+			hours, models.DefaultTimezone)
+		return models.DefaultTimezone
+	}
+	return timezone
+}
@@ -0,0 +1,24 @@
+package chats_test
+
+import (
+	"testing"
+
+	"reminder/storages/chats"
+	"reminder/storages/storagetest"
+)
+
+func TestInMemoryStorage(t *testing.T) {
+	storagetest.RunChatsStorage(t, func(t *testing.T) chats.Storage { return chats.NewInMemoryStorage() })
+}
+
+func TestMongoStorage(t *testing.T) {
+	mongo := storagetest.Mongo(t)
+	storagetest.RunChatsStorage(t, func(t *testing.T) chats.Storage {
+		storage, err := chats.NewMongoStorage(mongo.Database, mongo.NewCollection(t), mongo.User, mongo.Password,
+			mongo.Host, mongo.Port, mongo.Timeout, mongo.PoolSize, mongo.RetriesNum, mongo.RetriesInterval)
+		if err != nil {
+			t.Fatalf("mongo storage: %s", err)
+		}
+		return storage
+	})
+}
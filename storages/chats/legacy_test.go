@@ -0,0 +1,35 @@
+package chats
+
+import (
+	"testing"
+
+	"reminder/models"
+)
+
+func TestChatLegacyTimezone(t *testing.T) {
+	cases := []struct {
+		name   string
+		hours  int
+		expect string
+	}{
+		{"positive", 3, "+03:00"},
+		{"negative", -5, "-05:00"},
+		{"zero", 0, "+00:00"},
+		{"max", 14, "+14:00"},
+		{"min", -12, "-12:00"},
+		{"too big", 180, models.DefaultTimezone},
+		{"too small", -13, models.DefaultTimezone},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			hours := c.hours
+			chat, err := (&Chat{ChatID: 1, LegacyTimezone: &hours}).toModel()
+			if err != nil {
+				t.Fatalf("toModel: %s", err)
+			}
+			if chat.Timezone != c.expect {
+				t.Errorf("timezone %q, expected %q", chat.Timezone, c.expect)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package reminders
+
+import (
+	"reminder/models"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/globalsign/mgo/bson"
+	"github.com/pkg/errors"
+)
+
+// Delivery statuses, a reminder is scheduled until it's claimed by GetNext. The claimed reminder stays in flight
+// until it's acknowledged or its lease expires, failed deliveries are retried until they become dead.
+// Acknowledged and deleted reminders are archived.
+const (
+	StatusScheduled = "scheduled"
+	StatusInFlight  = "in_flight"
+	StatusRetrying  = "retrying"
+	StatusDead      = "dead"
+	StatusArchived  = "archived"
+)
+
+var (
+	DefaultDeliverySettings = DeliverySettings{
+		Lease:         2 * time.Minute,
+		RetryDelay:    30 * time.Second,
+		MaxRetryDelay: time.Hour,
+		MaxAttempts:   5,
+	}
+)
+
+// DeliverySettings.Lease is how long a claimed reminder is hidden from other readers, it must be longer than
+// the delivery itself. Retries are delayed exponentially starting from RetryDelay.
+type DeliverySettings struct {
+	Lease         time.Duration
+	RetryDelay    time.Duration
+	MaxRetryDelay time.Duration
+	MaxAttempts   int
+}
+
+// Validate rejects settings that would kill reminders without a delivery attempt or deliver them twice at once.
+func (ds *DeliverySettings) Validate() error {
+	if ds.MaxAttempts < 1 {
+		return errors.Errorf("max attempts must be at least 1, got %d", ds.MaxAttempts)
+	}
+	if ds.Lease <= 0 {
+		return errors.Errorf("lease must be positive, got %s", ds.Lease)
+	}
+	if ds.RetryDelay < 0 || ds.MaxRetryDelay < ds.RetryDelay {
+		return errors.Errorf("bad retry delays %s and %s", ds.RetryDelay, ds.MaxRetryDelay)
+	}
+	return nil
+}
+
+func (ds *DeliverySettings) retryDelay(attempts int) time.Duration {
+	delay := ds.RetryDelay
+	for i := 1; i < attempts && delay < ds.MaxRetryDelay; i++ {
+		delay *= 2
+	}
+	if delay > ds.MaxRetryDelay {
+		delay = ds.MaxRetryDelay
+	}
+	return delay
+}
+
+// nack returns the status and the lease of a failed delivery, dead reminders have no lease.
+func (ds *DeliverySettings) nack(reminder *models.Reminder, reason error, now time.Time) (string, *time.Time) {
+	if reminder.Attempts >= ds.MaxAttempts {
+		gLogger.WithFields(log.Fields{
+			"reminder_id": reminder.ID,
+			"chat_id":     reminder.ChatID,
+			"remind_at":   reminder.RemindAt,
+			"notify_at":   reminder.NotifyAt,
+		}).Errorf("Reminder is dead after %d attempts: %s", reminder.Attempts, reason)
+		return StatusDead, nil
+	}
+	retryAt := now.Add(ds.retryDelay(reminder.Attempts))
+	return StatusRetrying, &retryAt
+}
+
+// dueAt returns when the reminder can be claimed, the second result is false for dead and archived reminders.
+func (r *Reminder) dueAt() (time.Time, bool) {
+	notifyAt := r.RemindAt
+	if r.NotifyAt != nil {
+		notifyAt = *r.NotifyAt
+	}
+	switch r.Status {
+	case StatusDead, StatusArchived:
+		return time.Time{}, false
+	case StatusInFlight, StatusRetrying:
+		if r.LeaseUntil != nil && r.LeaseUntil.After(notifyAt) {
+			return *r.LeaseUntil, true
+		}
+	}
+	return notifyAt, true
+}
+
+// requeued returns a copy of the claimed reminder data with the schedule of next, the delivery state is reset.
+func (r *Reminder) requeued(next *Reminder) *Reminder {
+	updated := *r
+	updated.RemindAt, updated.FiredCount, updated.RecurrenceTime = next.RemindAt, next.FiredCount, next.RecurrenceTime
+	updated.AckPending, updated.NagRepeats, updated.OccurrenceAt = next.AckPending, next.NagRepeats, next.OccurrenceAt
+	updated.NotifyAt, updated.NotifyAhead = next.NotifyAt, next.NotifyAhead
+	updated.Status, updated.LeaseUntil, updated.Attempts, updated.LastError = StatusScheduled, nil, 0, ""
+	return &updated
+}
+
+// scheduleUpdate returns the fields set and unset by requeued.
+func scheduleUpdate(next *Reminder) (bson.M, bson.M) {
+	set := bson.M{
+		"remind_at":    next.RemindAt,
+		"fired_count":  next.FiredCount,
+		"ack_pending":  next.AckPending,
+		"nag_repeats":  next.NagRepeats,
+		"notify_at":    next.NotifyAt,
+		"notify_ahead": next.NotifyAhead,
+		"status":       StatusScheduled,
+		"attempts":     0,
+	}
+	unset := bson.M{"lease_until": "", "last_error": ""}
+	if next.OccurrenceAt != nil {
+		set["occurrence_at"] = next.OccurrenceAt
+	} else {
+		unset["occurrence_at"] = ""
+	}
+	if next.RecurrenceTime != nil {
+		set["recurrence_time"] = next.RecurrenceTime
+	} else {
+		unset["recurrence_time"] = ""
+	}
+	return set, unset
+}
+
+func (r *Reminder) isClaimedBy(reminder *models.Reminder) bool {
+	return r.Status == StatusInFlight && r.LeaseUntil != nil && r.LeaseUntil.Equal(reminder.LeaseUntil)
+}
@@ -0,0 +1,47 @@
+package reminders
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/globalsign/mgo"
+	"github.com/pkg/errors"
+)
+
+// indexes back the delivery claim, chat lists, the tag filter and the search. Tags use a multikey index,
+// the text index doesn't stem words, so it matches the same words in any language.
+var indexes = []mgo.Index{
+	{
+		Key:             []string{"chat_id", "$text:title", "$text:description"},
+		Weights:         map[string]int{"title": titleMatchWeight, "description": descriptionMatchWeight},
+		DefaultLanguage: "none",
+	},
+	{Key: []string{"reminder_id"}, Unique: true},
+	{Key: []string{"status", "notify_at"}},
+	{Key: []string{"chat_id", "status", "-created_at"}},
+	{Key: []string{"chat_id", "tags", "-created_at"}},
+	{Key: []string{"chat_id", "status", "-archived_at"}},
+}
+
+// EnsureIndexes creates missing indexes of the reminders collection, existing indexes are left as is.
+func EnsureIndexes(database, collection, user, password, host string, port, timeout int) error {
+	session, err := mgo.DialWithInfo(&mgo.DialInfo{
+		Addrs:    []string{fmt.Sprintf("%s:%d", host, port)},
+		Database: database,
+		Username: user,
+		Password: password,
+		Timeout:  time.Duration(timeout) * time.Second,
+	})
+	if err != nil {
+		return errors.Wrap(err, "mongo dial")
+	}
+	defer session.Close()
+	c := session.DB(database).C(collection)
+	for _, index := range indexes {
+		err := c.EnsureIndex(index)
+		if err != nil {
+			return errors.Wrapf(err, "ensure index %v", index.Key)
+		}
+	}
+	return nil
+}
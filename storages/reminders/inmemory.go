@@ -0,0 +1,242 @@
+package reminders
+
+import (
+	"context"
+	"reminder/models"
+	"sort"
+	"sync"
+	"time"
+)
+
+// InMemoryStorage keeps reminders in the process memory, GetNext blocks until the earliest reminder is due.
+type InMemoryStorage struct {
+	mx       sync.Mutex
+	storage  map[string]*Reminder
+	delivery *DeliverySettings
+	changed  chan struct{}
+	stopped  chan struct{}
+	stopOnce sync.Once
+}
+
+func NewInMemoryStorage(delivery *DeliverySettings) *InMemoryStorage {
+	if delivery == nil {
+		delivery = &DefaultDeliverySettings
+	}
+	return &InMemoryStorage{storage: make(map[string]*Reminder), delivery: delivery, changed: make(chan struct{}),
+		stopped: make(chan struct{})}
+}
+
+func (ms *InMemoryStorage) List(ctx context.Context, chatID int, filter ListFilter) ([]*models.Reminder, error) {
+	ms.mx.Lock()
+	var data []*Reminder
+	for _, reminderData := range ms.storage {
+		if reminderData.ChatID == chatID && reminderData.Status != StatusArchived && reminderData.matches(filter) {
+			data = append(data, reminderData)
+		}
+	}
+	ms.mx.Unlock()
+	sort.Slice(data, func(i, j int) bool { return data[i].CreatedAt.After(data[j].CreatedAt) })
+	return dataToModels(data)
+}
+
+func (ms *InMemoryStorage) ListArchived(ctx context.Context, chatID, limit int) ([]*models.Reminder, error) {
+	ms.mx.Lock()
+	var data []*Reminder
+	for _, reminderData := range ms.storage {
+		if reminderData.ChatID == chatID && reminderData.Status == StatusArchived {
+			data = append(data, reminderData)
+		}
+	}
+	ms.mx.Unlock()
+	sort.Slice(data, func(i, j int) bool { return data[i].ArchivedAt.After(*data[j].ArchivedAt) })
+	if limit > 0 && len(data) > limit {
+		data = data[:limit]
+	}
+	return dataToModels(data)
+}
+
+// Search matches words of the query against words of titles and descriptions.
+func (ms *InMemoryStorage) Search(ctx context.Context, chatID int, query string, limit int) ([]*models.Reminder, error) {
+	active, err := ms.List(ctx, chatID, ListFilter{})
+	if err != nil {
+		return nil, err
+	}
+	return rankByRelevance(active, query, limit), nil
+}
+
+func (ms *InMemoryStorage) Get(ctx context.Context, reminderID string) (*models.Reminder, error) {
+	ms.mx.Lock()
+	data, ok := ms.storage[reminderID]
+	ms.mx.Unlock()
+	if !ok {
+		return nil, nil
+	}
+	return data.toModel()
+}
+
+func (ms *InMemoryStorage) Delete(ctx context.Context, reminderID string) error {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	data, ok := ms.storage[reminderID]
+	if ok && data.Status != StatusArchived {
+		ms.archive(data, models.ArchivedDeleted)
+	}
+	return nil
+}
+
+func (ms *InMemoryStorage) Save(ctx context.Context, reminder *models.Reminder) error {
+	data := DataFromModel(reminder)
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	ms.storage[reminder.ID] = data
+	ms.notifyChanged()
+	return nil
+}
+
+func (ms *InMemoryStorage) GetNext(ctx context.Context) (*models.Reminder, bool) {
+	for {
+		select {
+		case <-ms.stopped:
+			return nil, false
+		default:
+		}
+		ms.mx.Lock()
+		next, dueAt := ms.earliest()
+		changed := ms.changed
+		var timer *time.Timer
+		var due <-chan time.Time
+		if next != nil {
+			delay := time.Until(dueAt)
+			if delay <= 0 {
+				// the stored data is replaced, not changed, readers convert it outside the lock
+				leaseUntil := time.Now().UTC().Add(ms.delivery.Lease)
+				claimed := *next
+				claimed.Status = StatusInFlight
+				claimed.LeaseUntil = &leaseUntil
+				claimed.Attempts++
+				ms.storage[claimed.ReminderID] = &claimed
+				ms.mx.Unlock()
+				reminder, err := claimed.toModel()
+				if err != nil {
+					gLogger.Errorf("Fetched bad reminder data: %s", err)
+					continue
+				}
+				return reminder, true
+			}
+			timer = time.NewTimer(delay)
+			due = timer.C
+		}
+		ms.mx.Unlock()
+		select {
+		case <-due:
+		case <-changed:
+		case <-ms.stopped:
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (ms *InMemoryStorage) StopGivingMsgs() {
+	ms.stopOnce.Do(func() {
+		close(ms.stopped)
+	})
+}
+
+func (ms *InMemoryStorage) Ack(ctx context.Context, reminder *models.Reminder) error {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	data, ok := ms.storage[reminder.ID]
+	if ok && data.isClaimedBy(reminder) {
+		ms.archive(data, models.ArchivedFired)
+	}
+	return nil
+}
+
+func (ms *InMemoryStorage) Requeue(ctx context.Context, reminder *models.Reminder) (bool, error) {
+	next := DataFromModel(reminder)
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	data, ok := ms.storage[reminder.ID]
+	if !ok || !data.isClaimedBy(reminder) {
+		return false, nil
+	}
+	ms.storage[reminder.ID] = data.requeued(next)
+	ms.notifyChanged()
+	return true, nil
+}
+
+func (ms *InMemoryStorage) Nack(ctx context.Context, reminder *models.Reminder, reason error) error {
+	ms.mx.Lock()
+	defer ms.mx.Unlock()
+	data, ok := ms.storage[reminder.ID]
+	if !ok || !data.isClaimedBy(reminder) {
+		return nil
+	}
+	status, leaseUntil := ms.delivery.nack(reminder, reason, time.Now().UTC())
+	updated := *data
+	updated.Status = status
+	updated.LastError = reason.Error()
+	if leaseUntil != nil {
+		updated.LeaseUntil = leaseUntil
+	}
+	ms.storage[reminder.ID] = &updated
+	ms.notifyChanged()
+	return nil
+}
+
+func (r *Reminder) matches(filter ListFilter) bool {
+	if !r.hasTag(filter.Tag) {
+		return false
+	}
+	if filter.UserID == 0 {
+		return true
+	}
+	reminder := &models.Reminder{CreatorID: r.CreatorID, AssigneeID: r.AssigneeID, Assignee: r.Assignee}
+	return reminder.BelongsTo(filter.UserID, filter.Username)
+}
+
+// hasTag is true for the empty tag.
+func (r *Reminder) hasTag(tag string) bool {
+	if tag == "" {
+		return true
+	}
+	for _, t := range r.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// archive must be called under the lock.
+func (ms *InMemoryStorage) archive(data *Reminder, kind string) {
+	archivedAt := time.Now().UTC()
+	archived := *data
+	archived.Status, archived.ArchivedAs, archived.ArchivedAt = StatusArchived, kind, &archivedAt
+	ms.storage[data.ReminderID] = &archived
+	ms.notifyChanged()
+}
+
+// earliest returns the reminder to deliver next and the time it's due at, dead reminders are never delivered.
+func (ms *InMemoryStorage) earliest() (*Reminder, time.Time) {
+	var next *Reminder
+	var nextDueAt time.Time
+	for _, reminderData := range ms.storage {
+		dueAt, ok := reminderData.dueAt()
+		if !ok {
+			continue
+		}
+		if next == nil || dueAt.Before(nextDueAt) {
+			next, nextDueAt = reminderData, dueAt
+		}
+	}
+	return next, nextDueAt
+}
+
+// notifyChanged wakes up readers waiting for the earliest reminder, must be called under the lock.
+func (ms *InMemoryStorage) notifyChanged() {
+	close(ms.changed)
+	ms.changed = make(chan struct{})
+}
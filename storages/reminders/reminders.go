@@ -3,6 +3,7 @@ package reminders
 import (
 	"context"
 	"reminder/models"
+	"reminder/recurrence"
 
 	"github.com/gazoon/bot_libs/logging"
 	"github.com/gazoon/bot_libs/mongo"
@@ -11,6 +12,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/globalsign/mgo/bson"
 
+	"strings"
 	"time"
 
 	"github.com/globalsign/mgo"
@@ -20,40 +22,123 @@ var (
 	gLogger = logging.WithPackage("notification_queue")
 )
 
+// Reader delivers reminders at least once. GetNext claims the earliest due reminder for the lease duration,
+// Ack removes it after a successful delivery and Nack schedules a retry. Requeue completes the delivery
+// with the new schedule of the reminder, e.g the next occurrence, only the schedule is written, so edits
+// made during the delivery aren't lost. Ack, Nack and Requeue are skipped if the reminder isn't claimed
+// by the delivery attempt anymore, e.g it's been snoozed or rescheduled meanwhile.
 type Reader interface {
 	GetNext(ctx context.Context) (*models.Reminder, bool)
+	Ack(ctx context.Context, reminder *models.Reminder) error
+	Nack(ctx context.Context, reminder *models.Reminder, reason error) error
+	// Requeue returns false if the reminder isn't claimed by the delivery attempt.
+	Requeue(ctx context.Context, reminder *models.Reminder) (bool, error)
 	StopGivingMsgs()
 }
 
+// ListFilter narrows the list down, the zero filter matches all active reminders of the chat.
+// A non zero UserID keeps reminders belonging to the user, see models.Reminder.BelongsTo.
+type ListFilter struct {
+	Tag      string
+	UserID   int
+	Username string
+}
+
+// Storage.List returns active reminders, Search finds active reminders by words of the title and the description,
+// the most relevant first. Delete moves a reminder to the archive, so it can be restored by saving
+// it without the archive kind.
+// Save writes the whole reminder and keeps its delivery state, so a failed reminder stays failed after an edit.
+// Saving a claimed reminder after models.Reminder.Reschedule ends the delivery attempt, the reader doesn't
+// overwrite the new schedule then. The save isn't conditional: a save of a reminder read before the reader
+// requeued it overwrites the next schedule with the previous one, the race is accepted as the reminder
+// is delivered again at worst.
 type Storage interface {
-	List(ctx context.Context, chatID int) ([]*models.Reminder, error)
+	List(ctx context.Context, chatID int, filter ListFilter) ([]*models.Reminder, error)
+	ListArchived(ctx context.Context, chatID, limit int) ([]*models.Reminder, error)
+	Search(ctx context.Context, chatID int, query string, limit int) ([]*models.Reminder, error)
 	Get(ctx context.Context, reminderID string) (*models.Reminder, error)
 	Delete(ctx context.Context, reminderID string) error
 	Save(ctx context.Context, reminder *models.Reminder) error
 }
 
 type MongoStorage struct {
-	client *mongo.Client
+	client   *mongo.Client
+	delivery *DeliverySettings
 	*queue.BaseConsumer
 }
 
 func NewMongoStorage(database, collection, user, password, host string, port, timeout, poolSize, retriesNum,
-	retriesInterval, fetchDelay int) (*MongoStorage, error) {
+	retriesInterval, fetchDelay int, delivery *DeliverySettings) (*MongoStorage, error) {
 
 	client, err := mongo.NewClient(database, collection, user, password, host, port, timeout, poolSize, retriesNum,
 		retriesInterval)
 	if err != nil {
 		return nil, err
 	}
-	return &MongoStorage{client: client, BaseConsumer: queue.NewBaseConsumer(fetchDelay)}, nil
+	if delivery == nil {
+		delivery = &DefaultDeliverySettings
+	}
+	return &MongoStorage{client: client, delivery: delivery, BaseConsumer: queue.NewBaseConsumer(fetchDelay)}, nil
 }
 
-func (ms *MongoStorage) List(ctx context.Context, chatID int) ([]*models.Reminder, error) {
+func (ms *MongoStorage) List(ctx context.Context, chatID int, filter ListFilter) ([]*models.Reminder, error) {
 	data := []*Reminder{}
-	err := ms.client.Find(ctx, bson.M{"chat_id": chatID}, "created_at", -1, -1, &data)
+	query := bson.M{"chat_id": chatID, "status": bson.M{"$ne": StatusArchived}}
+	if filter.Tag != "" {
+		query["tags"] = filter.Tag
+	}
+	if filter.UserID != 0 {
+		query["$or"] = belongsToQuery(filter.UserID, filter.Username)
+	}
+	err := ms.client.Find(ctx, query, "created_at", -1, -1, &data)
 	if err != nil {
 		return nil, errors.Wrap(err, "mongo find")
 	}
+	return dataToModels(data)
+}
+
+func belongsToQuery(userID int, username string) []bson.M {
+	unassigned := bson.M{"creator_id": userID, "assignee_id": bson.M{"$in": []interface{}{0, nil}},
+		"assignee": bson.M{"$in": []interface{}{"", nil}}}
+	conditions := []bson.M{{"assignee_id": userID}, unassigned}
+	if username != "" {
+		conditions = append(conditions, bson.M{"assignee": strings.ToLower(username)})
+	}
+	return conditions
+}
+
+// ListArchived returns the most recently archived reminders first.
+func (ms *MongoStorage) ListArchived(ctx context.Context, chatID, limit int) ([]*models.Reminder, error) {
+	data := []*Reminder{}
+	err := ms.client.Find(ctx, bson.M{"chat_id": chatID, "status": StatusArchived}, "-archived_at", -1, limit, &data)
+	if err != nil {
+		return nil, errors.Wrap(err, "mongo find")
+	}
+	return dataToModels(data)
+}
+
+// Search matches reminders with the text index, the index doesn't stem words, so matching is the same as
+// in the in-memory storage. The client can't project text scores, so all matched reminders of the chat
+// are loaded and ranked by the shared scoring, a limited unsorted find would drop the best matches.
+func (ms *MongoStorage) Search(ctx context.Context, chatID int, query string, limit int) ([]*models.Reminder, error) {
+	if len(tokenize(query)) == 0 {
+		return nil, nil
+	}
+	data := []*Reminder{}
+	err := ms.client.Find(ctx,
+		bson.M{"chat_id": chatID, "status": bson.M{"$ne": StatusArchived}, "$text": bson.M{"$search": query}},
+		"", -1, -1, &data)
+	if err != nil {
+		return nil, errors.Wrap(err, "mongo find")
+	}
+	candidates, err := dataToModels(data)
+	if err != nil {
+		return nil, err
+	}
+	return rankByRelevance(candidates, query, limit), nil
+}
+
+func dataToModels(data []*Reminder) ([]*models.Reminder, error) {
 	reminders := make([]*models.Reminder, len(data))
 	for i, reminderData := range data {
 		var err error
@@ -78,8 +163,17 @@ func (ms *MongoStorage) Get(ctx context.Context, reminderID string) (*models.Rem
 }
 
 func (ms *MongoStorage) Delete(ctx context.Context, reminderID string) error {
-	_, err := ms.client.Remove(ctx, bson.M{"reminder_id": reminderID})
-	return errors.Wrap(err, "mongo remove")
+	return ms.archive(ctx, bson.M{"reminder_id": reminderID, "status": bson.M{"$ne": StatusArchived}},
+		models.ArchivedDeleted)
+}
+
+func (ms *MongoStorage) archive(ctx context.Context, query bson.M, kind string) error {
+	update := bson.M{"status": StatusArchived, "archived_as": kind, "archived_at": time.Now().UTC()}
+	err := ms.client.FindAndModify(ctx, query, "", mgo.Change{Update: bson.M{"$set": update}}, &Reminder{})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return errors.Wrap(err, "mongo find and modify")
 }
 
 func (ms *MongoStorage) Save(ctx context.Context, reminder *models.Reminder) error {
@@ -98,12 +192,34 @@ func (ms *MongoStorage) GetNext(ctx context.Context) (*models.Reminder, bool) {
 	return reminder, isStopped
 }
 
+// tryGetNext claims a due reminder, reminders with an expired lease are claimed again,
+// e.g if the process crashed during the delivery.
 func (ms *MongoStorage) tryGetNext(ctx context.Context) (*models.Reminder, bool) {
+	now := time.Now().UTC()
+	leaseUntil := now.Add(ms.delivery.Lease)
 	result := &Reminder{}
 	err := ms.client.FindAndModify(ctx,
-		bson.M{"remind_at": bson.M{"$lt": time.Now().UTC()}},
-		"remind_at",
-		mgo.Change{Remove: true},
+		bson.M{
+			"$and": []bson.M{
+				{"$or": []bson.M{
+					{"notify_at": bson.M{"$lt": now}},
+					{"notify_at": bson.M{"$exists": false}, "remind_at": bson.M{"$lt": now}},
+				}},
+				{"$or": []bson.M{
+					{"status": bson.M{"$exists": false}},
+					{"status": StatusScheduled},
+					{"status": bson.M{"$in": []string{StatusInFlight, StatusRetrying}}, "lease_until": bson.M{"$lt": now}},
+				}},
+			},
+		},
+		"notify_at",
+		mgo.Change{
+			Update: bson.M{
+				"$set": bson.M{"status": StatusInFlight, "lease_until": leaseUntil},
+				"$inc": bson.M{"attempts": 1},
+			},
+			ReturnNew: true,
+		},
 		result)
 	if err != nil {
 		if err != mgo.ErrNotFound {
@@ -119,6 +235,42 @@ func (ms *MongoStorage) tryGetNext(ctx context.Context) (*models.Reminder, bool)
 	return reminder, true
 }
 
+// Ack archives the fired reminder, it's skipped if the reminder has been rescheduled during the delivery.
+func (ms *MongoStorage) Ack(ctx context.Context, reminder *models.Reminder) error {
+	return ms.archive(ctx, bson.M{"reminder_id": reminder.ID, "lease_until": reminder.LeaseUntil},
+		models.ArchivedFired)
+}
+
+func (ms *MongoStorage) Requeue(ctx context.Context, reminder *models.Reminder) (bool, error) {
+	set, unset := scheduleUpdate(DataFromModel(reminder))
+	err := ms.client.FindAndModify(ctx,
+		bson.M{"reminder_id": reminder.ID, "status": StatusInFlight, "lease_until": reminder.LeaseUntil},
+		"",
+		mgo.Change{Update: bson.M{"$set": set, "$unset": unset}},
+		&Reminder{})
+	if err == mgo.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, errors.Wrap(err, "mongo find and modify")
+}
+
+func (ms *MongoStorage) Nack(ctx context.Context, reminder *models.Reminder, reason error) error {
+	status, leaseUntil := ms.delivery.nack(reminder, reason, time.Now().UTC())
+	update := bson.M{"status": status, "last_error": reason.Error()}
+	if leaseUntil != nil {
+		update["lease_until"] = *leaseUntil
+	}
+	err := ms.client.FindAndModify(ctx,
+		bson.M{"reminder_id": reminder.ID, "lease_until": reminder.LeaseUntil},
+		"",
+		mgo.Change{Update: bson.M{"$set": update}},
+		&Reminder{})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return errors.Wrap(err, "mongo find and modify")
+}
+
 type Reminder struct {
 	ReminderID string    `bson:"reminder_id"`
 	ChatID     int       `bson:"chat_id"`
@@ -126,18 +278,89 @@ type Reminder struct {
 	RemindAt   time.Time `bson:"remind_at"`
 	CreatedAt  time.Time `bson:"created_at"`
 
-	Description *string `bson:"description"`
+	Description *string  `bson:"description"`
+	Recurrence  string   `bson:"recurrence,omitempty"`
+	FiredCount  int      `bson:"fired_count"`
+	Tags        []string `bson:"tags,omitempty"`
+
+	// RecurrenceTime is the time of day of occurrences in seconds, see recurrence.Rule.TimeOfDay
+	RecurrenceTime *int `bson:"recurrence_time,omitempty"`
+
+	CreatorID  int    `bson:"creator_id,omitempty"`
+	AssigneeID int    `bson:"assignee_id,omitempty"`
+	Assignee   string `bson:"assignee,omitempty"`
+
+	NagInterval   int        `bson:"nag_interval,omitempty"`
+	NagMaxRepeats int        `bson:"nag_max_repeats,omitempty"`
+	AckPending    bool       `bson:"ack_pending,omitempty"`
+	NagRepeats    int        `bson:"nag_repeats,omitempty"`
+	OccurrenceAt  *time.Time `bson:"occurrence_at,omitempty"`
+
+	NotifyBefore []int `bson:"notify_before,omitempty"`
+	// NotifyAt is missing in reminders saved before advance notifications, they fire at the remind time
+	NotifyAt    *time.Time `bson:"notify_at,omitempty"`
+	NotifyAhead int        `bson:"notify_ahead,omitempty"`
+
+	ArchivedAs string     `bson:"archived_as,omitempty"`
+	ArchivedAt *time.Time `bson:"archived_at,omitempty"`
+
+	Status     string     `bson:"status"`
+	LeaseUntil *time.Time `bson:"lease_until,omitempty"`
+	Attempts   int        `bson:"attempts"`
+	LastError  string     `bson:"last_error,omitempty"`
 }
 
 func DataFromModel(m *models.Reminder) *Reminder {
-	return &Reminder{
+	data := &Reminder{
 		ReminderID:  m.ID,
 		ChatID:      m.ChatID,
 		Title:       m.Title,
 		RemindAt:    m.RemindAt,
 		CreatedAt:   m.CreatedAt,
 		Description: m.Description,
+		FiredCount:  m.FiredCount,
+		Tags:        m.Tags,
+
+		CreatorID:  m.CreatorID,
+		AssigneeID: m.AssigneeID,
+		Assignee:   m.Assignee,
+
+		NagInterval:   int(m.NagInterval / time.Second),
+		NagMaxRepeats: m.NagMaxRepeats,
+		AckPending:    m.AckPending,
+		NagRepeats:    m.NagRepeats,
+		OccurrenceAt:  m.OccurrenceAt,
+
+		ArchivedAs: m.ArchivedAs,
+		ArchivedAt: m.ArchivedAt,
+
+		Status:    m.DeliveryStatus,
+		Attempts:  m.Attempts,
+		LastError: m.LastError,
+	}
+	if data.Status == "" {
+		data.Status = StatusScheduled
 	}
+	if !m.LeaseUntil.IsZero() {
+		leaseUntil := m.LeaseUntil
+		data.LeaseUntil = &leaseUntil
+	}
+	if m.IsArchived() {
+		data.Status = StatusArchived
+	}
+	if m.Recurrence != nil {
+		data.Recurrence = m.Recurrence.String()
+		if m.Recurrence.TimeOfDay != nil {
+			seconds := int(*m.Recurrence.TimeOfDay / time.Second)
+			data.RecurrenceTime = &seconds
+		}
+	}
+	for _, before := range m.NotifyBefore {
+		data.NotifyBefore = append(data.NotifyBefore, int(before/time.Second))
+	}
+	notifyAt, ahead := m.NextNotification(time.Now().UTC())
+	data.NotifyAt, data.NotifyAhead = &notifyAt, int(ahead/time.Second)
+	return data
 }
 
 func (r *Reminder) toModel() (*models.Reminder, error) {
@@ -145,12 +368,57 @@ func (r *Reminder) toModel() (*models.Reminder, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "bad data for reminder")
 	}
-	return &models.Reminder{
+	var rule *recurrence.Rule
+	if r.Recurrence != "" {
+		rule, err = recurrence.Parse(r.Recurrence)
+		if err != nil {
+			return nil, errors.Wrap(err, "bad recurrence rule for reminder")
+		}
+		if r.RecurrenceTime != nil {
+			timeOfDay := time.Duration(*r.RecurrenceTime) * time.Second
+			rule.TimeOfDay = &timeOfDay
+		}
+	}
+	reminder := &models.Reminder{
 		ID:          r.ReminderID,
 		ChatID:      r.ChatID,
 		Title:       r.Title,
 		RemindAt:    r.RemindAt,
 		CreatedAt:   r.CreatedAt,
 		Description: r.Description,
-	}, nil
+		Recurrence:  rule,
+		FiredCount:  r.FiredCount,
+		Tags:        r.Tags,
+		Attempts:    r.Attempts,
+
+		CreatorID:  r.CreatorID,
+		AssigneeID: r.AssigneeID,
+		Assignee:   r.Assignee,
+
+		NagInterval:   time.Duration(r.NagInterval) * time.Second,
+		NagMaxRepeats: r.NagMaxRepeats,
+		AckPending:    r.AckPending,
+		NagRepeats:    r.NagRepeats,
+		OccurrenceAt:  r.OccurrenceAt,
+
+		NotifyAt:    r.RemindAt,
+		NotifyAhead: time.Duration(r.NotifyAhead) * time.Second,
+
+		ArchivedAs: r.ArchivedAs,
+		ArchivedAt: r.ArchivedAt,
+
+		Failed:    r.Status == StatusDead,
+		LastError: r.LastError,
+	}
+	for _, before := range r.NotifyBefore {
+		reminder.NotifyBefore = append(reminder.NotifyBefore, time.Duration(before)*time.Second)
+	}
+	if r.NotifyAt != nil {
+		reminder.NotifyAt = *r.NotifyAt
+	}
+	if r.LeaseUntil != nil {
+		reminder.LeaseUntil = *r.LeaseUntil
+	}
+	reminder.DeliveryStatus = r.Status
+	return reminder, nil
 }
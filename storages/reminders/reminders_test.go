@@ -0,0 +1,41 @@
+package reminders_test
+
+import (
+	"testing"
+
+	"reminder/storages/reminders"
+	"reminder/storages/storagetest"
+)
+
+func TestInMemoryStorage(t *testing.T) {
+	storagetest.RunRemindersStorage(t, func(t *testing.T) reminders.Storage {
+		return reminders.NewInMemoryStorage(nil)
+	})
+	storagetest.RunRemindersQueue(t, func(t *testing.T) storagetest.RemindersQueue {
+		return reminders.NewInMemoryStorage(&storagetest.QueueDelivery)
+	})
+}
+
+func TestMongoStorage(t *testing.T) {
+	mongo := storagetest.Mongo(t)
+	newStorage := func(t *testing.T, delivery *reminders.DeliverySettings) *reminders.MongoStorage {
+		collection := mongo.NewCollection(t)
+		// the search needs the text index
+		err := reminders.EnsureIndexes(mongo.Database, collection, mongo.User, mongo.Password, mongo.Host, mongo.Port,
+			mongo.Timeout)
+		if err != nil {
+			t.Fatalf("mongo indexes: %s", err)
+		}
+		storage, err := reminders.NewMongoStorage(mongo.Database, collection, mongo.User, mongo.Password, mongo.Host,
+			mongo.Port, mongo.Timeout, mongo.PoolSize, mongo.RetriesNum, mongo.RetriesInterval, mongo.FetchDelay,
+			delivery)
+		if err != nil {
+			t.Fatalf("mongo storage: %s", err)
+		}
+		return storage
+	}
+	storagetest.RunRemindersStorage(t, func(t *testing.T) reminders.Storage { return newStorage(t, nil) })
+	storagetest.RunRemindersQueue(t, func(t *testing.T) storagetest.RemindersQueue {
+		return newStorage(t, &storagetest.QueueDelivery)
+	})
+}
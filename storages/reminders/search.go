@@ -0,0 +1,73 @@
+package reminders
+
+import (
+	"reminder/models"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+const (
+	titleMatchWeight       = 3
+	descriptionMatchWeight = 1
+)
+
+// tokenize splits text into lowercased words, it's close to the mongo text index without stemming.
+func tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// searchScore is zero if the reminder has none of the terms, title matches weigh more than description ones.
+func searchScore(reminder *models.Reminder, terms []string) int {
+	titleTokens := tokenSet(reminder.Title)
+	var descriptionTokens map[string]bool
+	if reminder.Description != nil {
+		descriptionTokens = tokenSet(*reminder.Description)
+	}
+	var score int
+	for _, term := range terms {
+		if titleTokens[term] {
+			score += titleMatchWeight
+		}
+		if descriptionTokens[term] {
+			score += descriptionMatchWeight
+		}
+	}
+	return score
+}
+
+func tokenSet(text string) map[string]bool {
+	tokens := tokenize(text)
+	set := make(map[string]bool, len(tokens))
+	for _, token := range tokens {
+		set[token] = true
+	}
+	return set
+}
+
+// rankByRelevance drops reminders without matches and sorts the rest by the score, newer reminders go first
+// among equally relevant ones. Both backends rank with it, so results don't depend on the storage.
+func rankByRelevance(reminders []*models.Reminder, query string, limit int) []*models.Reminder {
+	terms := tokenize(query)
+	scores := make(map[string]int, len(reminders))
+	var ranked []*models.Reminder
+	for _, reminder := range reminders {
+		score := searchScore(reminder, terms)
+		if score > 0 {
+			scores[reminder.ID] = score
+			ranked = append(ranked, reminder)
+		}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		if scores[ranked[i].ID] != scores[ranked[j].ID] {
+			return scores[ranked[i].ID] > scores[ranked[j].ID]
+		}
+		return ranked[i].CreatedAt.After(ranked[j].CreatedAt)
+	})
+	if limit > 0 && len(ranked) > limit {
+		ranked = ranked[:limit]
+	}
+	return ranked
+}
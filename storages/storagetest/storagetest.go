@@ -0,0 +1,818 @@
+// Package storagetest contains the conformance suite every storage backend must pass.
+// A backend test calls the suite with a constructor of an empty storage, e.g:
+//
+//	func TestInMemoryStorage(t *testing.T) {
+//		storagetest.RunChatsStorage(t, func(t *testing.T) chats.Storage { return chats.NewInMemoryStorage() })
+//	}
+//
+// The constructor is called for every test case with its t, mongo backends must return a storage with an empty
+// collection, see Mongo.
+// Reminders queues must be created with QueueDelivery settings, so retries don't slow the suite down.
+package storagetest
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"reminder/core"
+	"reminder/models"
+	"reminder/recurrence"
+	"reminder/storages/chats"
+	"reminder/storages/reminders"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	chatID      = 100500
+	otherChatID = 100501
+	waitTimeout = 5 * time.Second
+)
+
+var QueueDelivery = reminders.DeliverySettings{
+	Lease:         500 * time.Millisecond,
+	RetryDelay:    200 * time.Millisecond,
+	MaxRetryDelay: 300 * time.Millisecond,
+	MaxAttempts:   2,
+}
+
+type RemindersQueue interface {
+	reminders.Storage
+	reminders.Reader
+}
+
+func now() time.Time {
+	// mongo stores time with milliseconds precision
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+func newReminder(chat int, title string, remindAt time.Time) *models.Reminder {
+	description := title + " description"
+	reminder := models.NewReminder(chat, title, remindAt, &description)
+	reminder.CreatedAt = reminder.CreatedAt.Truncate(time.Millisecond)
+	return reminder
+}
+
+func RunSessionStorage(t *testing.T, newStorage func(t *testing.T) core.Storage) {
+	ctx := context.Background()
+	t.Run("get not existing", func(t *testing.T) {
+		storage := newStorage(t)
+		session, err := storage.Get(ctx, chatID)
+		if err != nil {
+			t.Fatalf("get failed: %s", err)
+		}
+		if session != nil {
+			t.Fatalf("expected nil session, got %s", session)
+		}
+	})
+	t.Run("save and get", func(t *testing.T) {
+		storage := newStorage(t)
+		session := core.NewSession(chatID)
+		session.LastPage = core.NewURL("reminder_list", "reminders", map[string]string{"page": "2"})
+		session.InputHandler = core.NewURL("reminder_creation", "on_title", nil)
+		session.AddIntent([]string{"home", "main"}, core.HomePageURL)
+		session.PagesStates["reminder_creation"] = map[string]interface{}{"title": "buy milk"}
+		session.GlobalState["key"] = "value"
+		session.PushHistory(ctx, core.HomePageURL)
+		session.PushHistory(ctx, session.LastPage)
+		mustSaveSession(t, storage, session)
+
+		stored := mustGetSession(t, storage, chatID)
+		if stored.ID != session.ID || stored.ChatID != session.ChatID {
+			t.Fatalf("expected session %s, got %s", session, stored)
+		}
+		if stored.LastPage.Encode() != session.LastPage.Encode() {
+			t.Errorf("last page %s != %s", stored.LastPage.Encode(), session.LastPage.Encode())
+		}
+		if stored.InputHandler.Encode() != session.InputHandler.Encode() {
+			t.Errorf("input handler %s != %s", stored.InputHandler.Encode(), session.InputHandler.Encode())
+		}
+		if len(stored.LocalIntents) != 1 || stored.LocalIntents[0].Handler.Encode() != core.HomePageURL.Encode() ||
+			len(stored.LocalIntents[0].Words) != 2 {
+			t.Errorf("unexpected local intents %v", stored.LocalIntents)
+		}
+		if title := stored.PagesStates["reminder_creation"]["title"]; title != "buy milk" {
+			t.Errorf("unexpected page state value %v", title)
+		}
+		if value := stored.GlobalState["key"]; value != "value" {
+			t.Errorf("unexpected global state value %v", value)
+		}
+		if len(stored.History) != 2 || stored.History[0].Encode() != core.HomePageURL.Encode() ||
+			stored.History[1].Encode() != session.LastPage.Encode() {
+			t.Errorf("unexpected history %v", stored.History)
+		}
+	})
+	t.Run("save overwrites", func(t *testing.T) {
+		storage := newStorage(t)
+		session := core.NewSession(chatID)
+		session.LastPage = core.HomePageURL
+		mustSaveSession(t, storage, session)
+		session.LastPage = core.NotFoundPageURL
+		mustSaveSession(t, storage, session)
+
+		stored := mustGetSession(t, storage, chatID)
+		if stored.LastPage.Encode() != core.NotFoundPageURL.Encode() {
+			t.Fatalf("expected overwritten last page, got %s", stored.LastPage.Encode())
+		}
+	})
+	t.Run("save conflict", func(t *testing.T) {
+		storage := newStorage(t)
+		session := core.NewSession(chatID)
+		session.LastPage = core.HomePageURL
+		mustSaveSession(t, storage, session)
+		first := mustGetSession(t, storage, chatID)
+		second := mustGetSession(t, storage, chatID)
+		mustSaveSession(t, storage, first)
+		err := storage.Save(ctx, second)
+		if !core.IsConflict(err) {
+			t.Fatalf("expected conflict error, got %v", err)
+		}
+		mustSaveSession(t, storage, first)
+
+		concurrentNew := core.NewSession(chatID)
+		concurrentNew.LastPage = core.HomePageURL
+		err = storage.Save(ctx, concurrentNew)
+		if !core.IsConflict(err) {
+			t.Fatalf("expected conflict error for concurrently created session, got %v", err)
+		}
+	})
+	t.Run("delete", func(t *testing.T) {
+		storage := newStorage(t)
+		session := core.NewSession(chatID)
+		session.LastPage = core.HomePageURL
+		mustSaveSession(t, storage, session)
+		otherSession := core.NewSession(otherChatID)
+		otherSession.LastPage = core.HomePageURL
+		mustSaveSession(t, storage, otherSession)
+		err := storage.Delete(ctx, session)
+		if err != nil {
+			t.Fatalf("delete failed: %s", err)
+		}
+		if stored, _ := storage.Get(ctx, chatID); stored != nil {
+			t.Fatalf("session wasn't deleted: %s", stored)
+		}
+		mustGetSession(t, storage, otherChatID)
+	})
+	t.Run("delete inactive", func(t *testing.T) {
+		storage := newStorage(t)
+		inactive := core.NewSession(chatID)
+		inactive.LastPage = core.HomePageURL
+		inactive.Touch(now().Add(-time.Hour))
+		mustSaveSession(t, storage, inactive)
+		active := core.NewSession(otherChatID)
+		active.LastPage = core.HomePageURL
+		active.Touch(now())
+		mustSaveSession(t, storage, active)
+		err := storage.DeleteInactive(ctx, now().Add(-time.Minute))
+		if err != nil {
+			t.Fatalf("delete inactive failed: %s", err)
+		}
+		if stored, _ := storage.Get(ctx, chatID); stored != nil {
+			t.Fatalf("inactive session wasn't deleted: %s", stored)
+		}
+		mustGetSession(t, storage, otherChatID)
+	})
+	t.Run("delete inactive keeps sessions without activity", func(t *testing.T) {
+		storage := newStorage(t)
+		session := core.NewSession(chatID)
+		session.LastPage = core.HomePageURL
+		mustSaveSession(t, storage, session)
+		err := storage.DeleteInactive(ctx, now().Add(-time.Minute))
+		if err != nil {
+			t.Fatalf("delete inactive failed: %s", err)
+		}
+		mustGetSession(t, storage, chatID)
+	})
+}
+
+func mustSaveSession(t *testing.T, storage core.Storage, session *core.Session) {
+	t.Helper()
+	err := storage.Save(context.Background(), session)
+	if err != nil {
+		t.Fatalf("save failed: %s", err)
+	}
+}
+
+func mustGetSession(t *testing.T, storage core.Storage, chat int) *core.Session {
+	t.Helper()
+	session, err := storage.Get(context.Background(), chat)
+	if err != nil {
+		t.Fatalf("get failed: %s", err)
+	}
+	if session == nil {
+		t.Fatalf("session for chat %d not found", chat)
+	}
+	return session
+}
+
+func RunChatsStorage(t *testing.T, newStorage func(t *testing.T) chats.Storage) {
+	ctx := context.Background()
+	t.Run("get not existing", func(t *testing.T) {
+		storage := newStorage(t)
+		chat, err := storage.Get(ctx, chatID)
+		if err != nil {
+			t.Fatalf("get failed: %s", err)
+		}
+		if chat != nil {
+			t.Fatalf("expected nil chat, got %+v", chat)
+		}
+	})
+	t.Run("save and get", func(t *testing.T) {
+		storage := newStorage(t)
+		chat := models.NewChat(chatID, "Europe/Berlin")
+		mustSaveChat(t, storage, chat)
+		stored := mustGetChat(t, storage, chatID)
+		if *stored != *chat {
+			t.Fatalf("expected chat %+v, got %+v", chat, stored)
+		}
+	})
+	t.Run("save overwrites", func(t *testing.T) {
+		storage := newStorage(t)
+		chat := models.NewChat(chatID, "Europe/Berlin")
+		mustSaveChat(t, storage, chat)
+		chat.Timezone = "+05:30"
+		mustSaveChat(t, storage, chat)
+		stored := mustGetChat(t, storage, chatID)
+		if *stored != *chat {
+			t.Fatalf("expected chat %+v, got %+v", chat, stored)
+		}
+	})
+}
+
+func mustSaveChat(t *testing.T, storage chats.Storage, chat *models.Chat) {
+	t.Helper()
+	err := storage.Save(context.Background(), chat)
+	if err != nil {
+		t.Fatalf("save failed: %s", err)
+	}
+}
+
+func mustGetChat(t *testing.T, storage chats.Storage, chat int) *models.Chat {
+	t.Helper()
+	stored, err := storage.Get(context.Background(), chat)
+	if err != nil {
+		t.Fatalf("get failed: %s", err)
+	}
+	if stored == nil {
+		t.Fatalf("chat %d not found", chat)
+	}
+	return stored
+}
+
+func RunRemindersStorage(t *testing.T, newStorage func(t *testing.T) reminders.Storage) {
+	ctx := context.Background()
+	t.Run("get not existing", func(t *testing.T) {
+		storage := newStorage(t)
+		reminder, err := storage.Get(ctx, "not_existing")
+		if err != nil {
+			t.Fatalf("get failed: %s", err)
+		}
+		if reminder != nil {
+			t.Fatalf("expected nil reminder, got %s", reminder)
+		}
+	})
+	t.Run("save and get", func(t *testing.T) {
+		storage := newStorage(t)
+		reminder := newReminder(chatID, "buy milk", now().Add(time.Hour))
+		mustSaveReminder(t, storage, reminder)
+		stored := mustGetReminder(t, storage, reminder.ID)
+		assertRemindersEqual(t, reminder, stored)
+	})
+	t.Run("save and get recurring", func(t *testing.T) {
+		storage := newStorage(t)
+		reminder := newReminder(chatID, "buy milk", now().Add(time.Hour))
+		reminder.Recurrence = recurrence.NewRule(recurrence.Weekly)
+		reminder.Recurrence.Weekdays = []time.Weekday{time.Monday, time.Friday}
+		timeOfDay := 9*time.Hour + 30*time.Minute
+		reminder.Recurrence.TimeOfDay = &timeOfDay
+		reminder.FiredCount = 2
+		reminder.Tags = []string{"shopping"}
+		mustSaveReminder(t, storage, reminder)
+		stored := mustGetReminder(t, storage, reminder.ID)
+		assertRemindersEqual(t, reminder, stored)
+	})
+	t.Run("save overwrites", func(t *testing.T) {
+		storage := newStorage(t)
+		reminder := newReminder(chatID, "buy milk", now().Add(time.Hour))
+		mustSaveReminder(t, storage, reminder)
+		reminder.Title = "buy bread"
+		reminder.RemindAt = reminder.RemindAt.Add(time.Hour)
+		mustSaveReminder(t, storage, reminder)
+		stored := mustGetReminder(t, storage, reminder.ID)
+		assertRemindersEqual(t, reminder, stored)
+	})
+	t.Run("list", func(t *testing.T) {
+		storage := newStorage(t)
+		first := newReminder(chatID, "first", now().Add(time.Hour))
+		second := newReminder(chatID, "second", now().Add(time.Hour))
+		second.CreatedAt = first.CreatedAt.Add(time.Second)
+		other := newReminder(otherChatID, "other", now().Add(time.Hour))
+		for _, reminder := range []*models.Reminder{first, second, other} {
+			mustSaveReminder(t, storage, reminder)
+		}
+		list, err := storage.List(ctx, chatID, reminders.ListFilter{})
+		if err != nil {
+			t.Fatalf("list failed: %s", err)
+		}
+		if len(list) != 2 {
+			t.Fatalf("expected 2 reminders of the chat, got %v", list)
+		}
+		assertRemindersEqual(t, second, list[0])
+		assertRemindersEqual(t, first, list[1])
+	})
+	t.Run("list by tag", func(t *testing.T) {
+		storage := newStorage(t)
+		work := newReminder(chatID, "work", now().Add(time.Hour))
+		work.Tags = []string{"work", "urgent"}
+		home := newReminder(chatID, "home", now().Add(time.Hour))
+		home.Tags = []string{"home"}
+		untagged := newReminder(chatID, "untagged", now().Add(time.Hour))
+		otherWork := newReminder(otherChatID, "other work", now().Add(time.Hour))
+		otherWork.Tags = []string{"work"}
+		for _, reminder := range []*models.Reminder{work, home, untagged, otherWork} {
+			mustSaveReminder(t, storage, reminder)
+		}
+		list, err := storage.List(ctx, chatID, reminders.ListFilter{Tag: "work"})
+		if err != nil {
+			t.Fatalf("list failed: %s", err)
+		}
+		if len(list) != 1 {
+			t.Fatalf("expected 1 reminder tagged work, got %v", list)
+		}
+		assertRemindersEqual(t, work, list[0])
+		all, err := storage.List(ctx, chatID, reminders.ListFilter{})
+		if err != nil {
+			t.Fatalf("list failed: %s", err)
+		}
+		if len(all) != 3 {
+			t.Fatalf("expected all 3 reminders without filter, got %v", all)
+		}
+	})
+	t.Run("list mine", func(t *testing.T) {
+		const userID, otherUserID = 10, 20
+		storage := newStorage(t)
+		created := newReminder(chatID, "created by me", now().Add(time.Hour))
+		created.CreatorID = userID
+		assignedByID := newReminder(chatID, "assigned to me by id", now().Add(time.Hour))
+		assignedByID.CreatorID = otherUserID
+		assignedByID.Assign(userID, "")
+		assignedByName := newReminder(chatID, "assigned to me by username", now().Add(time.Hour))
+		assignedByName.CreatorID = otherUserID
+		assignedByName.Assign(0, "alice")
+		createdForOther := newReminder(chatID, "created by me for bob", now().Add(time.Hour))
+		createdForOther.CreatorID = userID
+		createdForOther.Assign(0, "bob")
+		others := newReminder(chatID, "others", now().Add(time.Hour))
+		others.CreatorID = otherUserID
+		for i, reminder := range []*models.Reminder{created, assignedByID, assignedByName, createdForOther, others} {
+			reminder.CreatedAt = now().Add(time.Duration(i) * time.Second)
+			mustSaveReminder(t, storage, reminder)
+		}
+		list, err := storage.List(ctx, chatID, reminders.ListFilter{UserID: userID, Username: "Alice"})
+		if err != nil {
+			t.Fatalf("list failed: %s", err)
+		}
+		if len(list) != 3 {
+			t.Fatalf("expected 3 reminders of the user, got %v", list)
+		}
+		assertRemindersEqual(t, assignedByName, list[0])
+		assertRemindersEqual(t, assignedByID, list[1])
+		assertRemindersEqual(t, created, list[2])
+	})
+	t.Run("delete archives", func(t *testing.T) {
+		storage := newStorage(t)
+		reminder := newReminder(chatID, "buy milk", now().Add(time.Hour))
+		mustSaveReminder(t, storage, reminder)
+		err := storage.Delete(ctx, reminder.ID)
+		if err != nil {
+			t.Fatalf("delete failed: %s", err)
+		}
+		stored := mustGetReminder(t, storage, reminder.ID)
+		assertArchived(t, stored, models.ArchivedDeleted)
+		list, err := storage.List(ctx, chatID, reminders.ListFilter{})
+		if err != nil {
+			t.Fatalf("list failed: %s", err)
+		}
+		if len(list) != 0 {
+			t.Fatalf("deleted reminder is listed: %v", list)
+		}
+	})
+	t.Run("list archived", func(t *testing.T) {
+		storage := newStorage(t)
+		active := newReminder(chatID, "active", now().Add(time.Hour))
+		first := newReminder(chatID, "first", now().Add(-time.Hour))
+		first.Archive(models.ArchivedFired, now().Add(-time.Minute))
+		second := newReminder(chatID, "second", now().Add(-time.Hour))
+		second.Archive(models.ArchivedDone, now())
+		other := newReminder(otherChatID, "other", now().Add(-time.Hour))
+		other.Archive(models.ArchivedDone, now())
+		for _, reminder := range []*models.Reminder{active, first, second, other} {
+			mustSaveReminder(t, storage, reminder)
+		}
+		archived, err := storage.ListArchived(ctx, chatID, 10)
+		if err != nil {
+			t.Fatalf("list archived failed: %s", err)
+		}
+		if len(archived) != 2 {
+			t.Fatalf("expected 2 archived reminders of the chat, got %v", archived)
+		}
+		assertRemindersEqual(t, second, archived[0])
+		assertArchived(t, archived[0], models.ArchivedDone)
+		assertRemindersEqual(t, first, archived[1])
+		limited, err := storage.ListArchived(ctx, chatID, 1)
+		if err != nil {
+			t.Fatalf("list archived failed: %s", err)
+		}
+		if len(limited) != 1 {
+			t.Fatalf("expected the limited list, got %v", limited)
+		}
+	})
+	t.Run("restore", func(t *testing.T) {
+		storage := newStorage(t)
+		reminder := newReminder(chatID, "buy milk", now().Add(time.Hour))
+		mustSaveReminder(t, storage, reminder)
+		err := storage.Delete(ctx, reminder.ID)
+		if err != nil {
+			t.Fatalf("delete failed: %s", err)
+		}
+		restored := mustGetReminder(t, storage, reminder.ID)
+		restored.Restore()
+		mustSaveReminder(t, storage, restored)
+		list, err := storage.List(ctx, chatID, reminders.ListFilter{})
+		if err != nil {
+			t.Fatalf("list failed: %s", err)
+		}
+		if len(list) != 1 || list[0].IsArchived() {
+			t.Fatalf("expected the restored reminder, got %v", list)
+		}
+	})
+	t.Run("search", func(t *testing.T) {
+		storage := newStorage(t)
+		description := "call the dentist about milk teeth"
+		byDescription := newReminder(chatID, "appointment", now().Add(time.Hour))
+		byDescription.Description = &description
+		byTitle := newReminder(chatID, "buy milk", now().Add(time.Hour))
+		unmatched := newReminder(chatID, "pay taxes", now().Add(time.Hour))
+		archived := newReminder(chatID, "milk the cow", now().Add(-time.Hour))
+		archived.Archive(models.ArchivedDone, now())
+		other := newReminder(otherChatID, "milk", now().Add(time.Hour))
+		for _, reminder := range []*models.Reminder{byDescription, byTitle, unmatched, archived, other} {
+			mustSaveReminder(t, storage, reminder)
+		}
+		found, err := storage.Search(ctx, chatID, "Milk", 10)
+		if err != nil {
+			t.Fatalf("search failed: %s", err)
+		}
+		if len(found) != 2 {
+			t.Fatalf("expected 2 found reminders of the chat, got %v", found)
+		}
+		assertRemindersEqual(t, byTitle, found[0])
+		assertRemindersEqual(t, byDescription, found[1])
+		limited, err := storage.Search(ctx, chatID, "milk", 1)
+		if err != nil {
+			t.Fatalf("search failed: %s", err)
+		}
+		if len(limited) != 1 {
+			t.Fatalf("expected the limited result, got %v", limited)
+		}
+		nothing, err := storage.Search(ctx, chatID, "bread", 10)
+		if err != nil {
+			t.Fatalf("search failed: %s", err)
+		}
+		if len(nothing) != 0 {
+			t.Fatalf("expected nothing found, got %v", nothing)
+		}
+	})
+}
+
+func assertArchived(t *testing.T, reminder *models.Reminder, kind string) {
+	t.Helper()
+	if reminder.ArchivedAs != kind || reminder.ArchivedAt == nil {
+		t.Fatalf("expected reminder archived as %s, got %+v", kind, reminder)
+	}
+}
+
+func RunRemindersQueue(t *testing.T, newStorage func(t *testing.T) RemindersQueue) {
+	ctx := context.Background()
+	t.Run("due reminder", func(t *testing.T) {
+		storage := newStorage(t)
+		defer storage.StopGivingMsgs()
+		reminder := newReminder(chatID, "buy milk", now().Add(-time.Minute))
+		mustSaveReminder(t, storage, reminder)
+		fetched := mustGetNext(t, storage)
+		assertRemindersEqual(t, reminder, fetched)
+		if fetched.Attempts != 1 {
+			t.Fatalf("expected the first attempt, got %d", fetched.Attempts)
+		}
+		mustGetReminder(t, storage, reminder.ID)
+		err := storage.Ack(ctx, fetched)
+		if err != nil {
+			t.Fatalf("ack failed: %s", err)
+		}
+		assertArchived(t, mustGetReminder(t, storage, reminder.ID), models.ArchivedFired)
+	})
+	t.Run("archived reminder isn't delivered", func(t *testing.T) {
+		storage := newStorage(t)
+		defer storage.StopGivingMsgs()
+		deleted := newReminder(chatID, "deleted", now().Add(-time.Hour))
+		mustSaveReminder(t, storage, deleted)
+		err := storage.Delete(ctx, deleted.ID)
+		if err != nil {
+			t.Fatalf("delete failed: %s", err)
+		}
+		due := newReminder(chatID, "due", now().Add(-time.Minute))
+		mustSaveReminder(t, storage, due)
+		assertRemindersEqual(t, due, mustGetNext(t, storage))
+	})
+	t.Run("claimed reminder is hidden", func(t *testing.T) {
+		storage := newStorage(t)
+		defer storage.StopGivingMsgs()
+		claimed := newReminder(chatID, "claimed", now().Add(-time.Hour))
+		other := newReminder(chatID, "other", now().Add(-time.Minute))
+		mustSaveReminder(t, storage, claimed)
+		mustSaveReminder(t, storage, other)
+		assertRemindersEqual(t, claimed, mustGetNext(t, storage))
+		assertRemindersEqual(t, other, mustGetNext(t, storage))
+	})
+	t.Run("expired lease", func(t *testing.T) {
+		storage := newStorage(t)
+		defer storage.StopGivingMsgs()
+		reminder := newReminder(chatID, "buy milk", now().Add(-time.Minute))
+		mustSaveReminder(t, storage, reminder)
+		mustGetNext(t, storage)
+		redelivered := mustGetNext(t, storage)
+		assertRemindersEqual(t, reminder, redelivered)
+		if redelivered.Attempts != 2 {
+			t.Fatalf("expected the second attempt, got %d", redelivered.Attempts)
+		}
+	})
+	t.Run("retry and dead letter", func(t *testing.T) {
+		storage := newStorage(t)
+		defer storage.StopGivingMsgs()
+		reminder := newReminder(chatID, "buy milk", now().Add(-time.Minute))
+		mustSaveReminder(t, storage, reminder)
+		mustFailDelivery(t, storage)
+		dead := mustGetReminder(t, storage, reminder.ID)
+		if !dead.Failed || dead.LastError != "telegram is down" {
+			t.Fatalf("expected failed reminder, got %+v", dead)
+		}
+		later := newReminder(chatID, "later", now().Add(QueueDelivery.Lease*2))
+		mustSaveReminder(t, storage, later)
+		assertRemindersEqual(t, later, mustGetNext(t, storage))
+	})
+	t.Run("dead reminder stays dead after save", func(t *testing.T) {
+		storage := newStorage(t)
+		defer storage.StopGivingMsgs()
+		reminder := newReminder(chatID, "buy milk", now().Add(-time.Minute))
+		mustSaveReminder(t, storage, reminder)
+		mustFailDelivery(t, storage)
+		edited := mustGetReminder(t, storage, reminder.ID)
+		edited.AddTags("home")
+		mustSaveReminder(t, storage, edited)
+		stored := mustGetReminder(t, storage, reminder.ID)
+		if !stored.Failed || stored.LastError != "telegram is down" {
+			t.Fatalf("expected failed reminder after save, got %+v", stored)
+		}
+		later := newReminder(chatID, "later", now().Add(QueueDelivery.Lease*2))
+		mustSaveReminder(t, storage, later)
+		assertRemindersEqual(t, later, mustGetNext(t, storage))
+	})
+	t.Run("rescheduled dead reminder", func(t *testing.T) {
+		storage := newStorage(t)
+		defer storage.StopGivingMsgs()
+		reminder := newReminder(chatID, "buy milk", now().Add(-time.Minute))
+		mustSaveReminder(t, storage, reminder)
+		mustFailDelivery(t, storage)
+		dead := mustGetReminder(t, storage, reminder.ID)
+		dead.Reschedule()
+		mustSaveReminder(t, storage, dead)
+		fetched := mustGetNext(t, storage)
+		assertRemindersEqual(t, reminder, fetched)
+		if fetched.Attempts != 1 || fetched.Failed {
+			t.Fatalf("expected the first attempt of the rescheduled reminder, got %+v", fetched)
+		}
+	})
+	t.Run("save during delivery keeps attempts", func(t *testing.T) {
+		storage := newStorage(t)
+		defer storage.StopGivingMsgs()
+		reminder := newReminder(chatID, "buy milk", now().Add(-time.Minute))
+		mustSaveReminder(t, storage, reminder)
+		fetched := mustGetNext(t, storage)
+		edited := mustGetReminder(t, storage, reminder.ID)
+		edited.AddTags("home")
+		mustSaveReminder(t, storage, edited)
+		redelivered := mustGetNext(t, storage)
+		if redelivered.Attempts != 2 {
+			t.Fatalf("expected the second attempt, got %d", redelivered.Attempts)
+		}
+		err := storage.Ack(ctx, fetched)
+		if err != nil {
+			t.Fatalf("ack failed: %s", err)
+		}
+		if stored := mustGetReminder(t, storage, reminder.ID); stored.IsArchived() {
+			t.Fatalf("reminder is archived by the expired delivery: %+v", stored)
+		}
+	})
+	t.Run("rescheduled during delivery", func(t *testing.T) {
+		storage := newStorage(t)
+		defer storage.StopGivingMsgs()
+		reminder := newReminder(chatID, "buy milk", now().Add(-time.Minute))
+		mustSaveReminder(t, storage, reminder)
+		fetched := mustGetNext(t, storage)
+		rescheduled := *fetched
+		rescheduled.RemindAt = now().Add(time.Hour)
+		rescheduled.Reschedule()
+		mustSaveReminder(t, storage, &rescheduled)
+		err := storage.Ack(ctx, fetched)
+		if err != nil {
+			t.Fatalf("ack failed: %s", err)
+		}
+		stored := mustGetReminder(t, storage, reminder.ID)
+		if !stored.RemindAt.Equal(rescheduled.RemindAt) || stored.IsArchived() {
+			t.Fatalf("expected rescheduled reminder, got %s", stored)
+		}
+	})
+	t.Run("requeue", func(t *testing.T) {
+		storage := newStorage(t)
+		defer storage.StopGivingMsgs()
+		reminder := newReminder(chatID, "buy milk", now().Add(-time.Minute))
+		mustSaveReminder(t, storage, reminder)
+		fetched := mustGetNext(t, storage)
+		fetched.RemindAt = now().Add(-time.Second)
+		mustRequeue(t, storage, fetched, true)
+		redelivered := mustGetNext(t, storage)
+		if !redelivered.RemindAt.Equal(fetched.RemindAt) || redelivered.Attempts != 1 {
+			t.Fatalf("expected the first attempt at %s, got %+v", fetched.RemindAt, redelivered)
+		}
+	})
+	t.Run("requeue keeps edits made during delivery", func(t *testing.T) {
+		storage := newStorage(t)
+		defer storage.StopGivingMsgs()
+		reminder := newReminder(chatID, "buy milk", now().Add(-time.Minute))
+		mustSaveReminder(t, storage, reminder)
+		fetched := mustGetNext(t, storage)
+		edited := mustGetReminder(t, storage, reminder.ID)
+		edited.Title = "buy bread"
+		edited.AddTags("home")
+		mustSaveReminder(t, storage, edited)
+		fetched.RemindAt = now().Add(time.Hour)
+		mustRequeue(t, storage, fetched, true)
+		stored := mustGetReminder(t, storage, reminder.ID)
+		if stored.Title != edited.Title || len(stored.Tags) != 1 || !stored.RemindAt.Equal(fetched.RemindAt) {
+			t.Fatalf("expected the edit with the new schedule, got %+v", stored)
+		}
+	})
+	t.Run("requeue after reschedule during delivery", func(t *testing.T) {
+		storage := newStorage(t)
+		defer storage.StopGivingMsgs()
+		reminder := newReminder(chatID, "buy milk", now().Add(-time.Minute))
+		mustSaveReminder(t, storage, reminder)
+		fetched := mustGetNext(t, storage)
+		snoozed := mustGetReminder(t, storage, reminder.ID)
+		snoozed.RemindAt = now().Add(10 * time.Minute)
+		snoozed.Reschedule()
+		mustSaveReminder(t, storage, snoozed)
+		fetched.RemindAt = now().Add(time.Hour)
+		mustRequeue(t, storage, fetched, false)
+		if stored := mustGetReminder(t, storage, reminder.ID); !stored.RemindAt.Equal(snoozed.RemindAt) {
+			t.Fatalf("expected the snoozed reminder at %s, got %s", snoozed.RemindAt, stored.RemindAt)
+		}
+	})
+	t.Run("earliest first", func(t *testing.T) {
+		storage := newStorage(t)
+		defer storage.StopGivingMsgs()
+		later := newReminder(chatID, "later", now().Add(-time.Minute))
+		earlier := newReminder(chatID, "earlier", now().Add(-time.Hour))
+		mustSaveReminder(t, storage, later)
+		mustSaveReminder(t, storage, earlier)
+		assertRemindersEqual(t, earlier, mustGetNext(t, storage))
+		assertRemindersEqual(t, later, mustGetNext(t, storage))
+	})
+	t.Run("waits for remind at", func(t *testing.T) {
+		storage := newStorage(t)
+		defer storage.StopGivingMsgs()
+		remindAt := now().Add(time.Second)
+		reminder := newReminder(chatID, "buy milk", remindAt)
+		mustSaveReminder(t, storage, reminder)
+		fetched := mustGetNext(t, storage)
+		if time.Now().Before(remindAt) {
+			t.Fatalf("reminder fetched before its time %s", remindAt)
+		}
+		assertRemindersEqual(t, reminder, fetched)
+	})
+	t.Run("stop", func(t *testing.T) {
+		storage := newStorage(t)
+		mustSaveReminder(t, storage, newReminder(chatID, "buy milk", now().Add(time.Hour)))
+		result := make(chan bool)
+		go func() {
+			_, ok := storage.GetNext(ctx)
+			result <- ok
+		}()
+		storage.StopGivingMsgs()
+		select {
+		case ok := <-result:
+			if ok {
+				t.Fatal("reminder fetched after stop")
+			}
+		case <-time.After(waitTimeout):
+			t.Fatal("get next isn't unblocked by stop")
+		}
+	})
+}
+
+func mustRequeue(t *testing.T, reader reminders.Reader, reminder *models.Reminder, expected bool) {
+	t.Helper()
+	requeued, err := reader.Requeue(context.Background(), reminder)
+	if err != nil {
+		t.Fatalf("requeue failed: %s", err)
+	}
+	if requeued != expected {
+		t.Fatalf("expected requeued %t, got %t", expected, requeued)
+	}
+}
+
+// mustFailDelivery fails all delivery attempts of the earliest reminder.
+func mustFailDelivery(t *testing.T, reader reminders.Reader) {
+	t.Helper()
+	for attempt := 1; attempt <= QueueDelivery.MaxAttempts; attempt++ {
+		fetched := mustGetNext(t, reader)
+		if fetched.Attempts != attempt {
+			t.Fatalf("expected attempt %d, got %d", attempt, fetched.Attempts)
+		}
+		err := reader.Nack(context.Background(), fetched, errors.New("telegram is down"))
+		if err != nil {
+			t.Fatalf("nack failed: %s", err)
+		}
+	}
+}
+
+func mustGetNext(t *testing.T, reader reminders.Reader) *models.Reminder {
+	t.Helper()
+	type fetchResult struct {
+		reminder *models.Reminder
+		ok       bool
+	}
+	result := make(chan fetchResult, 1)
+	go func() {
+		reminder, ok := reader.GetNext(context.Background())
+		result <- fetchResult{reminder, ok}
+	}()
+	select {
+	case r := <-result:
+		if !r.ok {
+			t.Fatal("get next returned nothing")
+		}
+		return r.reminder
+	case <-time.After(waitTimeout):
+		t.Fatal("get next timeout")
+	}
+	return nil
+}
+
+func mustSaveReminder(t *testing.T, storage reminders.Storage, reminder *models.Reminder) {
+	t.Helper()
+	err := storage.Save(context.Background(), reminder)
+	if err != nil {
+		t.Fatalf("save failed: %s", err)
+	}
+}
+
+func mustGetReminder(t *testing.T, storage reminders.Storage, reminderID string) *models.Reminder {
+	t.Helper()
+	stored, err := storage.Get(context.Background(), reminderID)
+	if err != nil {
+		t.Fatalf("get failed: %s", err)
+	}
+	if stored == nil {
+		t.Fatalf("reminder %s not found", reminderID)
+	}
+	return stored
+}
+
+func assertRemindersEqual(t *testing.T, expected, actual *models.Reminder) {
+	t.Helper()
+	if expected.ID != actual.ID || expected.ChatID != actual.ChatID || expected.Title != actual.Title ||
+		!expected.RemindAt.Equal(actual.RemindAt) || !expected.CreatedAt.Equal(actual.CreatedAt) {
+		t.Fatalf("expected reminder %+v, got %+v", expected, actual)
+	}
+	if expected.FiredCount != actual.FiredCount ||
+		(expected.Recurrence == nil) != (actual.Recurrence == nil) ||
+		expected.Recurrence != nil && (expected.Recurrence.String() != actual.Recurrence.String() ||
+			!reflect.DeepEqual(expected.Recurrence.TimeOfDay, actual.Recurrence.TimeOfDay)) {
+		t.Fatalf("expected recurrence %v fired %d times, got %v fired %d times", expected.Recurrence,
+			expected.FiredCount, actual.Recurrence, actual.FiredCount)
+	}
+	if strings.Join(expected.Tags, ",") != strings.Join(actual.Tags, ",") {
+		t.Fatalf("expected tags %v, got %v", expected.Tags, actual.Tags)
+	}
+	if expected.CreatorID != actual.CreatorID || expected.AssigneeID != actual.AssigneeID ||
+		expected.Assignee != actual.Assignee {
+		t.Fatalf("expected creator %d and assignee %d %q, got creator %d and assignee %d %q", expected.CreatorID,
+			expected.AssigneeID, expected.Assignee, actual.CreatorID, actual.AssigneeID, actual.Assignee)
+	}
+	if (expected.Description == nil) != (actual.Description == nil) ||
+		expected.Description != nil && *expected.Description != *actual.Description {
+		t.Fatalf("expected description %v, got %v", expected.Description, actual.Description)
+	}
+}
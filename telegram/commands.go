@@ -0,0 +1,107 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gazoon/bot_libs/logging"
+	"github.com/pkg/errors"
+)
+
+const (
+	apiURLTemplate = "https://api.telegram.org/bot%s/%s"
+)
+
+var (
+	gLogger = logging.WithPackage("telegram")
+)
+
+type BotCommand struct {
+	Command     string `json:"command"`
+	Description string `json:"description"`
+}
+
+func (bc BotCommand) String() string {
+	return logging.ObjToString(&bc)
+}
+
+// Client covers bot api methods the messenger doesn't provide.
+type Client struct {
+	apiToken   string
+	httpClient *http.Client
+}
+
+func NewClient(apiToken string, httpTimeout int) *Client {
+	httpClient := &http.Client{Timeout: time.Duration(httpTimeout) * time.Second}
+	return &Client{apiToken: apiToken, httpClient: httpClient}
+}
+
+// SetMyCommands replaces the list of commands shown to users in the telegram commands menu.
+func (c *Client) SetMyCommands(ctx context.Context, commands []*BotCommand) error {
+	logger := logging.FromContextAndBase(ctx, gLogger)
+	logger.WithField("commands", commands).Info("Publish bot commands")
+	return c.call(ctx, "setMyCommands", map[string]interface{}{"commands": commands}, nil)
+}
+
+// EditMessageText replaces the text of the sent message and removes its inline buttons.
+func (c *Client) EditMessageText(ctx context.Context, chatID, msgID int, text string) error {
+	logger := logging.FromContextAndBase(ctx, gLogger)
+	logger.WithFields(log.Fields{"chat_id": chatID, "message_id": msgID}).Info("Edit message text")
+	params := map[string]interface{}{"chat_id": chatID, "message_id": msgID, "text": text}
+	return c.call(ctx, "editMessageText", params, nil)
+}
+
+// call sends params as json, the result is decoded if it isn't nil.
+func (c *Client) call(ctx context.Context, method string, params, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return errors.Wrap(err, "params marshal")
+	}
+	return c.post(ctx, method, "application/json", bytes.NewReader(body), result)
+}
+
+// post calls the method with the body in the content type.
+func (c *Client) post(ctx context.Context, method, contentType string, body io.Reader, result interface{}) error {
+	req, err := http.NewRequest(http.MethodPost, fmt.Sprintf(apiURLTemplate, c.apiToken, method), body)
+	if err != nil {
+		return errors.Wrap(withoutURL(err), "build http request")
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", contentType)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return errors.Wrapf(withoutURL(err), "%s request", method)
+	}
+	defer resp.Body.Close()
+	response := &struct {
+		Ok          bool            `json:"ok"`
+		Description string          `json:"description"`
+		Result      json.RawMessage `json:"result"`
+	}{}
+	err = json.NewDecoder(resp.Body).Decode(response)
+	if err != nil {
+		return errors.Wrapf(err, "%s response decode", method)
+	}
+	if !response.Ok {
+		return errors.Errorf("%s failed: %s", method, response.Description)
+	}
+	if result == nil {
+		return nil
+	}
+	return errors.Wrapf(json.Unmarshal(response.Result, result), "%s result decode", method)
+}
+
+// withoutURL drops the request url from the error, the url contains the bot token and errors get to the logs.
+func withoutURL(err error) error {
+	if urlErr, ok := err.(*url.Error); ok {
+		return errors.Wrap(urlErr.Err, urlErr.Op)
+	}
+	return err
+}
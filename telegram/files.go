@@ -0,0 +1,88 @@
+package telegram
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gazoon/bot_libs/logging"
+	"github.com/pkg/errors"
+)
+
+const (
+	fileURLTemplate = "https://api.telegram.org/file/bot%s/%s"
+	// maxDownloadSize is the bot api limit for downloaded files
+	maxDownloadSize = 20 << 20
+)
+
+// SendDocument sends the content as a file and returns the id of the sent message.
+func (c *Client) SendDocument(ctx context.Context, chatID int, fileName string, content []byte) (int, error) {
+	logger := logging.FromContextAndBase(ctx, gLogger)
+	logger.WithFields(log.Fields{"chat_id": chatID, "file_name": fileName}).Info("Send document")
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	err := writer.WriteField("chat_id", strconv.Itoa(chatID))
+	if err != nil {
+		return 0, errors.Wrap(err, "write chat id")
+	}
+	part, err := writer.CreateFormFile("document", fileName)
+	if err != nil {
+		return 0, errors.Wrap(err, "create document part")
+	}
+	_, err = part.Write(content)
+	if err != nil {
+		return 0, errors.Wrap(err, "write document")
+	}
+	err = writer.Close()
+	if err != nil {
+		return 0, errors.Wrap(err, "close multipart writer")
+	}
+	sent := &struct {
+		MessageID int `json:"message_id"`
+	}{}
+	err = c.post(ctx, "sendDocument", writer.FormDataContentType(), body, sent)
+	return sent.MessageID, err
+}
+
+// DownloadFile returns the content of a file uploaded to the bot.
+func (c *Client) DownloadFile(ctx context.Context, fileID string) ([]byte, error) {
+	logger := logging.FromContextAndBase(ctx, gLogger)
+	logger.WithField("file_id", fileID).Info("Download file")
+	file := &struct {
+		FilePath string `json:"file_path"`
+		FileSize int    `json:"file_size"`
+	}{}
+	err := c.call(ctx, "getFile", map[string]interface{}{"file_id": fileID}, file)
+	if err != nil {
+		return nil, err
+	}
+	if file.FileSize > maxDownloadSize {
+		return nil, errors.Errorf("file is too big: %d bytes", file.FileSize)
+	}
+	req, err := http.NewRequest(http.MethodGet, fmt.Sprintf(fileURLTemplate, c.apiToken, file.FilePath), nil)
+	if err != nil {
+		return nil, errors.Wrap(withoutURL(err), "build http request")
+	}
+	resp, err := c.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, errors.Wrap(withoutURL(err), "file request")
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.Errorf("file request failed: %s", resp.Status)
+	}
+	content, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxDownloadSize+1))
+	if err != nil {
+		return nil, errors.Wrap(err, "read file")
+	}
+	if len(content) > maxDownloadSize {
+		return nil, errors.New("file is too big")
+	}
+	return content, nil
+}
@@ -0,0 +1,218 @@
+package timezones
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	// the zones database is embedded, so servers without tzdata installed resolve zones the same way
+	_ "time/tzdata"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	maxSearchResults = 5
+	maxOffset        = 14 * time.Hour
+	minOffset        = -12 * time.Hour
+	offsetStep       = 15 * time.Minute
+)
+
+var (
+	offsetRegexp    = regexp.MustCompile(`^(?:utc|gmt)?\s*([+-])(\d{1,2})(?::?(\d{2}))?$`)
+	localTimeRegexp = regexp.MustCompile(`(?:^|\s)(\d{1,2})[:.](\d{2})\s*(am|pm)?(?:\s|$)`)
+
+	// cityAliases covers big cities that don't name their zone
+	cityAliases = map[string]string{
+		"delhi": "Asia/Kolkata", "new delhi": "Asia/Kolkata", "mumbai": "Asia/Kolkata", "bangalore": "Asia/Kolkata",
+		"beijing": "Asia/Shanghai", "hong kong": "Asia/Hong_Kong", "saint petersburg": "Europe/Moscow",
+		"st petersburg": "Europe/Moscow", "washington": "America/New_York", "boston": "America/New_York",
+		"miami": "America/New_York", "san francisco": "America/Los_Angeles", "seattle": "America/Los_Angeles",
+		"munich": "Europe/Berlin", "hamburg": "Europe/Berlin", "barcelona": "Europe/Madrid",
+		"milan": "Europe/Rome", "kiev": "Europe/Kyiv", "osaka": "Asia/Tokyo", "melbourne": "Australia/Melbourne",
+		"istanbul": "Europe/Istanbul", "dubai": "Asia/Dubai", "novosibirsk": "Asia/Novosibirsk",
+	}
+
+	cacheMx   sync.RWMutex
+	locations = map[string]*time.Location{}
+)
+
+// Load returns the location of an IANA zone name or of a fixed offset like "+05:30", locations are cached.
+func Load(name string) (*time.Location, error) {
+	cacheMx.RLock()
+	loc, ok := locations[name]
+	cacheMx.RUnlock()
+	if ok {
+		return loc, nil
+	}
+	if offset, ok := parseOffset(name); ok {
+		loc = time.FixedZone("UTC"+FormatOffset(offset), int(offset/time.Second))
+	} else {
+		var err error
+		loc, err = time.LoadLocation(name)
+		if err != nil || name == "" || name == "Local" {
+			return nil, errors.Errorf("unknown timezone %q", name)
+		}
+	}
+	cacheMx.Lock()
+	locations[name] = loc
+	cacheMx.Unlock()
+	return loc, nil
+}
+
+// Resolve turns user input into zone names: an IANA name or an offset give exactly one result,
+// otherwise zones are searched by the city, the result is empty if nothing is found.
+func Resolve(query string) []string {
+	query = strings.TrimSpace(query)
+	if offset, ok := parseOffset(query); ok {
+		return []string{FormatOffset(offset)}
+	}
+	normalized := strings.ToLower(strings.Join(strings.Fields(query), " "))
+	if normalized == "" {
+		return nil
+	}
+	for _, name := range zoneNames {
+		if strings.ToLower(name) == normalized {
+			return []string{name}
+		}
+	}
+	if strings.Contains(query, "/") {
+		if _, err := Load(query); err == nil {
+			return []string{query}
+		}
+	}
+	if normalized == "utc" || normalized == "gmt" {
+		return []string{"UTC"}
+	}
+	if name, ok := cityAliases[normalized]; ok {
+		return []string{name}
+	}
+	city := strings.Replace(normalized, " ", "_", -1)
+	var exact, partial []string
+	for _, name := range zoneNames {
+		zoneCity := strings.ToLower(name[strings.LastIndex(name, "/")+1:])
+		if zoneCity == city {
+			exact = append(exact, name)
+		} else if strings.Contains(zoneCity, city) {
+			partial = append(partial, name)
+		}
+	}
+	if len(exact) != 0 {
+		return exact
+	}
+	if len(partial) > maxSearchResults {
+		partial = partial[:maxSearchResults]
+	}
+	return partial
+}
+
+// IsFixedOffset reports whether the zone is a fixed utc offset, unlike a zone name it doesn't follow daylight saving.
+func IsFixedOffset(name string) bool {
+	_, ok := parseOffset(name)
+	return ok
+}
+
+// FormatOffset formats an offset like "+05:30".
+func FormatOffset(offset time.Duration) string {
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return fmt.Sprintf("%s%02d:%02d", sign, int(offset/time.Hour), int(offset%time.Hour/time.Minute))
+}
+
+// InferZone finds a zone from the current local time typed by the user, e.g "it's 14:35 here".
+// The utc offset is rounded to 15 minutes, so a few minutes of difference or typing delay don't matter.
+// The time alone doesn't tell the zone, so a zone with this offset now is picked: the preferred zone
+// (e.g the current one of the chat) if it matches, otherwise a zone of its region, otherwise a populous one.
+// Unlike a fixed offset, the zone follows daylight saving changes. The offset is returned if no zone has it.
+func InferZone(text string, now time.Time, preferred string) (string, bool) {
+	match := localTimeRegexp.FindStringSubmatch(strings.ToLower(text))
+	if match == nil {
+		return "", false
+	}
+	hour, _ := strconv.Atoi(match[1])
+	minute, _ := strconv.Atoi(match[2])
+	if match[3] != "" {
+		if hour < 1 || hour > 12 {
+			return "", false
+		}
+		hour %= 12
+		if match[3] == "pm" {
+			hour += 12
+		}
+	}
+	if hour > 23 || minute > 59 {
+		return "", false
+	}
+	now = now.UTC()
+	local := time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute
+	utc := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute
+	offset := (local - utc).Round(offsetStep)
+	if offset < minOffset {
+		offset += 24 * time.Hour
+	} else if offset > maxOffset {
+		offset -= 24 * time.Hour
+	}
+	if zone := zoneWithOffset(offset, now, preferred); zone != "" {
+		return zone, true
+	}
+	return FormatOffset(offset), true
+}
+
+func zoneWithOffset(offset time.Duration, now time.Time, preferred string) string {
+	hasOffset := func(name string) bool {
+		loc, err := Load(name)
+		if err != nil {
+			return false
+		}
+		_, zoneOffset := now.In(loc).Zone()
+		return time.Duration(zoneOffset)*time.Second == offset
+	}
+	if strings.Contains(preferred, "/") && hasOffset(preferred) {
+		return preferred
+	}
+	region := "-"
+	if idx := strings.Index(preferred, "/"); idx != -1 {
+		region = preferred[:idx+1]
+	}
+	// populous zones are checked first, so the fallback is a populous zone of another region
+	fallback := ""
+	for _, names := range [][]string{populousZones, zoneNames} {
+		for _, name := range names {
+			if !hasOffset(name) {
+				continue
+			}
+			if strings.HasPrefix(name, region) {
+				return name
+			}
+			if fallback == "" {
+				fallback = name
+			}
+		}
+	}
+	return fallback
+}
+
+func parseOffset(s string) (time.Duration, bool) {
+	match := offsetRegexp.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if match == nil {
+		return 0, false
+	}
+	hours, _ := strconv.Atoi(match[2])
+	minutes, _ := strconv.Atoi(match[3])
+	if minutes >= 60 {
+		return 0, false
+	}
+	offset := time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute
+	if match[1] == "-" {
+		offset = -offset
+	}
+	if offset < minOffset || offset > maxOffset {
+		return 0, false
+	}
+	return offset, true
+}
@@ -0,0 +1,149 @@
+package timezones
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseOffset(t *testing.T) {
+	cases := []struct {
+		text     string
+		offset   time.Duration
+		isOffset bool
+	}{
+		{"+3", 3 * time.Hour, true},
+		{"-05:30", -5*time.Hour - 30*time.Minute, true},
+		{"+0545", 5*time.Hour + 45*time.Minute, true},
+		{"UTC+5:30", 5*time.Hour + 30*time.Minute, true},
+		{"gmt -3", -3 * time.Hour, true},
+		{" +00:00 ", 0, true},
+		{"+14", maxOffset, true},
+		{"-12", minOffset, true},
+		{"+14:15", 0, false},
+		{"-12:30", 0, false},
+		{"+15", 0, false},
+		{"+5:60", 0, false},
+		{"5", 0, false},
+		{"", 0, false},
+		{"Europe/Berlin", 0, false},
+	}
+	for _, c := range cases {
+		t.Run(c.text, func(t *testing.T) {
+			offset, ok := parseOffset(c.text)
+			if ok != c.isOffset || offset != c.offset {
+				t.Errorf("expected %s %t, got %s %t", c.offset, c.isOffset, offset, ok)
+			}
+		})
+	}
+}
+
+func TestResolve(t *testing.T) {
+	cases := []struct {
+		query    string
+		expected []string
+	}{
+		{"Europe/Berlin", []string{"Europe/Berlin"}},
+		{"europe/berlin", []string{"Europe/Berlin"}},
+		{"Berlin", []string{"Europe/Berlin"}},
+		{"  new   york ", []string{"America/New_York"}},
+		{"Delhi", []string{"Asia/Kolkata"}},
+		{"utc", []string{"UTC"}},
+		{"UTC+3", []string{"+03:00"}},
+		{"-9:30", []string{"-09:30"}},
+		{"", nil},
+		{"atlantis", nil},
+	}
+	for _, c := range cases {
+		t.Run(c.query, func(t *testing.T) {
+			result := Resolve(c.query)
+			if len(result) == 0 && len(c.expected) == 0 {
+				return
+			}
+			if !reflect.DeepEqual(result, c.expected) {
+				t.Errorf("expected %v, got %v", c.expected, result)
+			}
+		})
+	}
+}
+
+func TestInferZone(t *testing.T) {
+	winter := time.Date(2030, 1, 15, 12, 0, 0, 0, time.UTC)
+	summer := time.Date(2030, 7, 15, 12, 0, 0, 0, time.UTC)
+	cases := []struct {
+		name      string
+		text      string
+		now       time.Time
+		preferred string
+		expected  string
+	}{
+		{"preferred zone", "it's 15:02 here", winter, "Europe/Moscow", "Europe/Moscow"},
+		{"zone of the preferred region", "14:00", winter, "Europe/Moscow", "Europe/Kyiv"},
+		{"daylight saving", "14:00", summer, "Europe/Berlin", "Europe/Berlin"},
+		{"12 hours clock", "2.00 pm", summer, "Europe/Berlin", "Europe/Berlin"},
+		{"rounded", "14:07", summer, "Europe/Berlin", "Europe/Berlin"},
+		{"no zone with offset", "14:30", winter, "", "+02:30"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			zone, ok := InferZone(c.text, c.now, c.preferred)
+			if !ok || zone != c.expected {
+				t.Errorf("expected %s, got %s %t", c.expected, zone, ok)
+			}
+		})
+	}
+}
+
+// the local time and the utc time can be on different days, so the offset is wrapped to the zones range
+func TestInferZoneOffsetWrap(t *testing.T) {
+	cases := []struct {
+		name     string
+		text     string
+		now      time.Time
+		expected time.Duration
+	}{
+		{"below min offset", "10:00", time.Date(2030, 1, 15, 23, 0, 0, 0, time.UTC), 11 * time.Hour},
+		{"min offset", "11:00", time.Date(2030, 1, 15, 23, 0, 0, 0, time.UTC), minOffset},
+		{"above max offset", "16:00", time.Date(2030, 1, 15, 1, 0, 0, 0, time.UTC), -9 * time.Hour},
+		{"max offset", "14:00", time.Date(2030, 1, 15, 0, 0, 0, 0, time.UTC), maxOffset},
+		{"above max offset with minutes", "14:15", time.Date(2030, 1, 15, 0, 0, 0, 0, time.UTC),
+			-9*time.Hour - 45*time.Minute},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			zone, ok := InferZone(c.text, c.now, "")
+			if !ok {
+				t.Fatalf("expected a zone for %s", c.text)
+			}
+			loc, err := Load(zone)
+			if err != nil {
+				t.Fatalf("load %s: %s", zone, err)
+			}
+			_, offset := c.now.In(loc).Zone()
+			if time.Duration(offset)*time.Second != c.expected {
+				t.Errorf("expected offset %s, got %s of %s", FormatOffset(c.expected),
+					FormatOffset(time.Duration(offset)*time.Second), zone)
+			}
+		})
+	}
+}
+
+func TestInferZoneInvalid(t *testing.T) {
+	now := time.Date(2030, 1, 15, 12, 0, 0, 0, time.UTC)
+	for _, text := range []string{"", "hello", "25:00", "14:60", "13:00 pm", "0:30 am", "1430"} {
+		t.Run(text, func(t *testing.T) {
+			if zone, ok := InferZone(text, now, ""); ok {
+				t.Errorf("expected no zone, got %s", zone)
+			}
+		})
+	}
+}
+
+func TestIsFixedOffset(t *testing.T) {
+	if !IsFixedOffset("+03:00") {
+		t.Error("expected +03:00 to be a fixed offset")
+	}
+	if IsFixedOffset("Europe/Moscow") {
+		t.Error("expected Europe/Moscow not to be a fixed offset")
+	}
+}